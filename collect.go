@@ -0,0 +1,307 @@
+//Collection of the metrics of every selected entity, shared by single runs and watch mode
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"./heapster"
+	"./kube"
+	"./series"
+)
+
+// What to collect, from the flags
+type collector struct {
+	client     *heapster.Client
+	kubeClient *kube.Client
+	podFilter  *nameFilter
+	nodeFilter *nameFilter
+	patterns   []metricPattern
+	aggs       []aggregation
+	bucket     time.Duration
+
+	// Set once the Kubernetes API refused to list pods by label
+	labelsUnavailable bool
+	// Metrics listed for each entity type, discovered once for all polls
+	available map[string][]string
+	// Timestamp of the last point seen of each series, by seriesKey, when watching
+	lastSeen map[string]time.Time
+}
+
+// Key of the series of an entity metric in lastSeen
+// All aggregations of a metric are fetched together, so they share their key
+func seriesKey(labels series.Labels, metricType string) string {
+	key := labels.Copy(series.LabelMetric, metricType)
+	delete(key, series.LabelAggregation)
+	return key.String()
+}
+
+// Start of the request for a metric of an entity: the window start, or just
+// after the last point seen of it when watching
+func (c *collector) from(labels series.Labels, metricType string, start time.Time) time.Time {
+	if last, ok := c.lastSeen[seriesKey(labels, metricType)]; ok && !last.Before(start) {
+		return last.Add(time.Second)
+	}
+	return start
+}
+
+// Lists the selected entities, discovers their metrics and collects them over [start, end]
+// Results are printed and aligned to grid; requests failing for single entities are recorded in failures
+func (c *collector) collect(ctx context.Context, start, end time.Time, grid series.Grid, out *output, failures *failureLog) (series.Set, error) {
+	client := c.client
+
+	//Get list of node names
+	nodeNames, err := client.ListNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nodeNames = c.nodeFilter.filter(nodeNames)
+	if len(nodeNames) == 0 {
+		fmt.Printf("Error: No nodeNames returned\n")
+	}
+	//Get list of namespaces
+	namespaces, err := selectNamespaces(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	if len(namespaces) == 0 {
+		fmt.Printf("Error: No namespaces selected\n")
+	}
+	//Get list of pod names in each namespace, narrowed down by -selector and the name filters
+	podNames := make(map[string][]string)
+	for _, ns := range namespaces {
+		//A namespace that fails to list is recorded and collected without pods
+		names, err := client.ListPods(ctx, ns)
+		if err != nil {
+			failures.add(ns+" pods", err)
+			continue
+		}
+		names = c.podFilter.filter(names)
+		if *selector != "" && !c.labelsUnavailable {
+			//Only a refusal to list pods falls back to the name filters, any
+			//other error such as an invalid selector would collect every pod
+			selected, err := selectPodsByLabel(ctx, c.kubeClient, ns, *selector, names)
+			switch {
+			case heapster.IsForbidden(err):
+				fmt.Printf("Warning: cannot select pods by label, using name filters only: %v\n", err)
+				c.labelsUnavailable = true
+			case err != nil:
+				return nil, fmt.Errorf("cannot select pods by label %q: %v", *selector, err)
+			default:
+				names = selected
+			}
+		}
+		if len(names) == 0 {
+			fmt.Printf("Error: No podNames returned for namespace %s\n", ns)
+		}
+		podNames[ns] = names
+	}
+	//Get list of containers of each pod and free containers of each node
+	//Listing is spread over the worker pool like the metric requests
+	pool := newFetchPool(*workers)
+	containerLists := make(map[string][][]string)
+	containerErrs := make(map[string][]error)
+	for _, ns := range namespaces {
+		ns := ns
+		containerLists[ns] = make([][]string, len(podNames[ns]))
+		containerErrs[ns] = make([]error, len(podNames[ns]))
+		for k, podName := range podNames[ns] {
+			k, podName := k, podName
+			pool.add(func() {
+				containerLists[ns][k], containerErrs[ns][k] = client.ListPodContainers(ctx, ns, podName)
+			})
+		}
+	}
+	freeLists := make([][]string, len(nodeNames))
+	freeErrs := make([]error, len(nodeNames))
+	for k, nodeName := range nodeNames {
+		k, nodeName := k, nodeName
+		pool.add(func() {
+			freeLists[k], freeErrs[k] = client.ListFreeContainers(ctx, nodeName)
+		})
+	}
+	pool.run()
+
+	//A pod or node that fails to list is recorded and collected without containers
+	podContainers := make(map[string][]containerRef)
+	for _, ns := range namespaces {
+		for k, podName := range podNames[ns] {
+			if err := containerErrs[ns][k]; err != nil {
+				failures.add(ns+"/"+podName+" containers", err)
+				continue
+			}
+			for _, name := range containerLists[ns][k] {
+				podContainers[ns] = append(podContainers[ns], containerRef{podName, name})
+			}
+		}
+	}
+	freeContainers := make([]containerRef, 0)
+	for k, nodeName := range nodeNames {
+		if err := freeErrs[k]; err != nil {
+			failures.add(nodeName+" free containers", err)
+			continue
+		}
+		for _, name := range freeLists[k] {
+			freeContainers = append(freeContainers, containerRef{nodeName, name})
+		}
+	}
+
+	//Discover the metrics available for each entity type and select the ones asked for
+	if c.available == nil {
+		c.available = make(map[string][]string)
+	}
+	selection, err := discoverMetrics(ctx, client, c.patterns, c.available, nodeNames, namespaces, podNames, podContainers, freeContainers, failures)
+	if err != nil {
+		return nil, err
+	}
+	clusterMetricTypes := selection.cluster
+	nodeMetricTypes := selection.node
+	namespaceMetricTypes := selection.namespace
+	podMetricTypes := selection.pod
+	containerMetricTypes := selection.container
+	freeContainerMetricTypes := selection.freeContainer
+
+	//Entities of each type, in the order their series are printed and charted
+	//All series are labelled with the cluster, plus the node, namespace, pod and container they belong to
+	cluster := series.Labels{series.LabelCluster: "k8s-cluster"}
+	labels := cluster
+	clusterEntities := []entity{{
+		labels: labels,
+		fetch: func(metricType string) (*heapster.MetricResult, error) {
+			return client.ClusterMetric(ctx, metricType, c.from(labels, metricType, start), end)
+		},
+		aggregate: func(aggs []string, metricType string) (*heapster.MetricAggregationResult, error) {
+			return client.ClusterAggregation(ctx, aggs, metricType, c.bucket, c.from(labels, metricType, start), end)
+		},
+	}}
+	nodeEntities := make([]entity, 0)
+	for _, nodeName := range nodeNames {
+		nodeName := nodeName
+		labels := cluster.Copy(series.LabelNode, nodeName)
+		nodeEntities = append(nodeEntities, entity{
+			labels: labels,
+			fetch: func(metricType string) (*heapster.MetricResult, error) {
+				return client.NodeMetric(ctx, nodeName, metricType, c.from(labels, metricType, start), end)
+			},
+			aggregate: func(aggs []string, metricType string) (*heapster.MetricAggregationResult, error) {
+				return client.NodeAggregation(ctx, nodeName, aggs, metricType, c.bucket, c.from(labels, metricType, start), end)
+			},
+		})
+	}
+	nsEntities := make([]entity, 0)
+	for _, ns := range namespaces {
+		ns := ns
+		labels := cluster.Copy(series.LabelNamespace, ns)
+		nsEntities = append(nsEntities, entity{
+			labels: labels,
+			fetch: func(metricType string) (*heapster.MetricResult, error) {
+				return client.NamespaceMetric(ctx, ns, metricType, c.from(labels, metricType, start), end)
+			},
+			aggregate: func(aggs []string, metricType string) (*heapster.MetricAggregationResult, error) {
+				return client.NamespaceAggregation(ctx, ns, aggs, metricType, c.bucket, c.from(labels, metricType, start), end)
+			},
+		})
+	}
+	//Pods and containers per namespace
+	podEntities := make(map[string][]entity)
+	containerEntities := make(map[string][]entity)
+	for _, ns := range namespaces {
+		ns := ns
+		for _, podName := range podNames[ns] {
+			podName := podName
+			labels := cluster.Copy(series.LabelNamespace, ns, series.LabelPod, podName)
+			podEntities[ns] = append(podEntities[ns], entity{
+				labels: labels,
+				fetch: func(metricType string) (*heapster.MetricResult, error) {
+					return client.PodMetric(ctx, ns, podName, metricType, c.from(labels, metricType, start), end)
+				},
+				aggregate: func(aggs []string, metricType string) (*heapster.MetricAggregationResult, error) {
+					return client.PodAggregation(ctx, ns, podName, aggs, metricType, c.bucket, c.from(labels, metricType, start), end)
+				},
+			})
+		}
+		for _, ref := range podContainers[ns] {
+			ref := ref
+			labels := cluster.Copy(series.LabelNamespace, ns, series.LabelPod, ref.parent, series.LabelContainer, ref.name)
+			containerEntities[ns] = append(containerEntities[ns], entity{
+				labels: labels,
+				fetch: func(metricType string) (*heapster.MetricResult, error) {
+					return client.PodContainerMetric(ctx, ns, ref.parent, ref.name, metricType, c.from(labels, metricType, start), end)
+				},
+				aggregate: func(aggs []string, metricType string) (*heapster.MetricAggregationResult, error) {
+					return client.PodContainerAggregation(ctx, ns, ref.parent, ref.name, aggs, metricType, c.bucket, c.from(labels, metricType, start), end)
+				},
+			})
+		}
+	}
+	//Free containers of each node
+	freeEntities := make([]entity, 0)
+	for _, ref := range freeContainers {
+		ref := ref
+		labels := cluster.Copy(series.LabelNode, ref.parent, series.LabelContainer, ref.name)
+		freeEntities = append(freeEntities, entity{
+			labels: labels,
+			fetch: func(metricType string) (*heapster.MetricResult, error) {
+				return client.FreeContainerMetric(ctx, ref.parent, ref.name, metricType, c.from(labels, metricType, start), end)
+			},
+			aggregate: func(aggs []string, metricType string) (*heapster.MetricAggregationResult, error) {
+				return client.FreeContainerAggregation(ctx, ref.parent, ref.name, aggs, metricType, c.bucket, c.from(labels, metricType, start), end)
+			},
+		})
+	}
+
+	//Queue every metric request, then fetch them all concurrently
+	//With -aggregations every entity gets one series per aggregation,
+	//so the entities are replaced by the series they expand to
+	queue := func(metricTypes []string, entities []entity) ([][]*fetchJob, []entity) {
+		if len(c.aggs) > 0 {
+			return queueAggregationJobs(pool, metricTypes, entities, c.aggs)
+		}
+		return queueJobs(pool, metricTypes, entities), entities
+	}
+	clusterJobs, clusterEntities := queue(clusterMetricTypes, clusterEntities)
+	nodeJobs, nodeEntities := queue(nodeMetricTypes, nodeEntities)
+	nsJobs, nsEntities := queue(namespaceMetricTypes, nsEntities)
+	podJobs := make(map[string][][]*fetchJob)
+	containerJobs := make(map[string][][]*fetchJob)
+	for _, ns := range namespaces {
+		if *batchPods && len(c.aggs) == 0 {
+			from := func(k int, metricType string) time.Time {
+				return c.from(podEntities[ns][k].labels, metricType, start)
+			}
+			podJobs[ns] = queuePodListJobs(ctx, pool, client, ns, podNames[ns], podMetricTypes, podEntities[ns], from, end, *maxURLLength)
+		} else {
+			podJobs[ns], podEntities[ns] = queue(podMetricTypes, podEntities[ns])
+		}
+		containerJobs[ns], containerEntities[ns] = queue(containerMetricTypes, containerEntities[ns])
+	}
+	freeJobs, freeEntities := queue(freeContainerMetricTypes, freeEntities)
+	pool.run()
+
+	//Print the results in order and collect them into one set of labelled series
+	out.printf("\nCLUSTER METRICS\n")
+	collected := collectResults(clusterJobs, clusterMetricTypes, clusterEntities, grid, out, failures)
+
+	out.printf("\n\nNODE METRICS\n")
+	collected = append(collected, collectResults(nodeJobs, nodeMetricTypes, nodeEntities, grid, out, failures)...)
+
+	out.printf("\n\nNAMESPACE METRICS\n")
+	collected = append(collected, collectResults(nsJobs, namespaceMetricTypes, nsEntities, grid, out, failures)...)
+
+	out.printf("\n\nPOD METRICS\n")
+	for _, ns := range namespaces {
+		collected = append(collected, collectResults(podJobs[ns], podMetricTypes, podEntities[ns], grid, out, failures)...)
+	}
+
+	out.printf("\n\nCONTAINER METRICS\n")
+	for _, ns := range namespaces {
+		collected = append(collected, collectResults(containerJobs[ns], containerMetricTypes, containerEntities[ns], grid, out, failures)...)
+	}
+
+	out.printf("\n\nFREE CONTAINER METRICS\n")
+	collected = append(collected, collectResults(freeJobs, freeContainerMetricTypes, freeEntities, grid, out, failures)...)
+
+	return collected, nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"./heapster"
+	"./series"
+	"./seriestest"
+)
+
+// A fake Heapster model API over a fixed cluster
+// Every metric of an entity has the same value at each of its points, looked
+// up in values by the entity's name, e.g. node-1, web, web/api-1/app or "" for
+// the cluster, so that each series can be told apart.
+type fakeHeapster struct {
+	nodes          []string
+	freeContainers map[string][]string //by node
+	pods           map[string][]string //by namespace
+	containers     map[string][]string //by namespace/pod
+	values         map[string]float64
+}
+
+func (f *fakeHeapster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1/model")
+	if i := strings.Index(path, "/metrics/"); i >= 0 {
+		f.serveMetric(w, path[:i], path[i+len("/metrics/"):])
+		return
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	var list []string
+	switch {
+	case path == "/nodes/":
+		list = f.nodes
+	case len(parts) == 3 && parts[2] == "freecontainers":
+		list = f.freeContainers[parts[1]]
+	case path == "/namespaces/":
+		for ns := range f.pods {
+			list = append(list, ns)
+		}
+		sort.Strings(list)
+	case len(parts) == 3 && parts[2] == "pods":
+		list = f.pods[parts[1]]
+	case len(parts) == 5 && parts[4] == "containers":
+		list = f.containers[parts[1]+"/"+parts[3]]
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if list == nil {
+		list = []string{}
+	}
+	writeJSONList(w, list)
+}
+
+// Serves the metrics listing of an entity, a metric of it, or a metric of a list of pods
+func (f *fakeHeapster) serveMetric(w http.ResponseWriter, entityPath, metric string) {
+	if metric == "" {
+		writeJSONList(w, []string{"cpu/usage_rate", "memory/usage"})
+		return
+	}
+	parts := strings.Split(strings.Trim(entityPath, "/"), "/")
+	if len(parts) == 4 && parts[2] == "pod-list" {
+		var items []string
+		for _, pod := range strings.Split(parts[3], ",") {
+			items = append(items, f.result(parts[1]+"/"+pod))
+		}
+		fmt.Fprintf(w, `{"items": [%s]}`, strings.Join(items, ","))
+		return
+	}
+	//e.g. nodes/node-1/freecontainers/kubelet or namespaces/web/pods/api-1
+	var names []string
+	for i := 1; i < len(parts); i += 2 {
+		names = append(names, parts[i])
+	}
+	fmt.Fprint(w, f.result(strings.Join(names, "/")))
+}
+
+// Two points a minute apart with the value of the entity
+func (f *fakeHeapster) result(entity string) string {
+	v := f.values[entity]
+	return fmt.Sprintf(`{"metrics": [{"timestamp": %q, "value": %v}, {"timestamp": %q, "value": %v}], "latestTimestamp": %q}`,
+		seriestest.Start.Format(time.RFC3339), v, seriestest.Start.Add(time.Minute).Format(time.RFC3339), v,
+		seriestest.Start.Add(time.Minute).Format(time.RFC3339))
+}
+
+func writeJSONList(w http.ResponseWriter, list []string) {
+	fmt.Fprintf(w, `[%s]`, strings.Join(quoteAll(list), ","))
+}
+
+func quoteAll(list []string) []string {
+	quoted := make([]string, len(list))
+	for i, s := range list {
+		quoted[i] = fmt.Sprintf("%q", s)
+	}
+	return quoted
+}
+
+// A cluster of two nodes and three namespaces, one of them without pods
+func testCluster() *fakeHeapster {
+	return &fakeHeapster{
+		nodes:          []string{"node-1", "node-2"},
+		freeContainers: map[string][]string{"node-1": {"kubelet"}, "node-2": {"kubelet", "docker-daemon"}},
+		pods:           map[string][]string{"web": {"api-1", "api-2"}, "db": {"pg-0"}, "idle": nil},
+		containers:     map[string][]string{"web/api-1": {"app", "proxy"}, "web/api-2": {"app"}, "db/pg-0": {"postgres"}},
+		values: map[string]float64{
+			"": 1000, "node-1": 400, "node-2": 600, "web": 300, "db": 100,
+			"web/api-1": 200, "web/api-2": 100, "db/pg-0": 100,
+			"web/api-1/app": 150, "web/api-1/proxy": 50, "web/api-2/app": 100, "db/pg-0/postgres": 100,
+			"node-1/kubelet": 20, "node-2/kubelet": 30, "node-2/docker-daemon": 10,
+		},
+	}
+}
+
+// Collects cpu/usage_rate from fake with the given -namespace and -all-namespaces flags
+// Returns the series by entity name and entity type, e.g. "web/api-1 pod"
+func collectFrom(t *testing.T, fake *fakeHeapster, namespaces string, all bool) map[string]*series.Series {
+	defer func(list string, all bool) {
+		*namespaceList, *allNamespaces = list, all
+	}(*namespaceList, *allNamespaces)
+	*namespaceList, *allNamespaces = namespaces, all
+
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	noFilter, _ := newNameFilter("", "")
+	c := &collector{
+		client:     heapster.NewClient(server.URL),
+		podFilter:  noFilter,
+		nodeFilter: noFilter,
+		patterns:   parseMetricPatterns("cpu/usage_rate"),
+	}
+	failures := &failureLog{}
+	set, err := c.collect(context.Background(), seriestest.Start, seriestest.Start.Add(time.Minute), seriestest.Grid(2),
+		&output{raw: true, quiet: true}, failures)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(failures.failures) > 0 {
+		t.Errorf("failures: %v", failures.failures)
+	}
+
+	byName := make(map[string]*series.Series)
+	for _, s := range set {
+		if s.Metric() != "cpu/usage_rate" {
+			t.Errorf("%s: collected %s", s.Name(), s.Metric())
+		}
+		byName[s.Name()+" "+s.Labels.Type()] = s
+	}
+	return byName
+}
+
+// Checks that collected has exactly the series named in want, each with the
+// value of its entity at both points
+func checkCollected(t *testing.T, fake *fakeHeapster, collected map[string]*series.Series, want []string) {
+	var got []string
+	for name := range collected {
+		got = append(got, name)
+	}
+	sort.Strings(got)
+	sort.Strings(want)
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("collected\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	for name, s := range collected {
+		entity := strings.SplitN(name, " ", 2)[0]
+		if s.Labels.Type() == series.TypeCluster {
+			entity = ""
+		}
+		v := fake.values[entity]
+		if len(s.Points) != 2 || s.Points[0].Value != v || s.Points[1].Value != v {
+			t.Errorf("%s: got points %v, want two of %v", name, s.Points, v)
+		}
+	}
+}
+
+func TestCollectNamespace(t *testing.T) {
+	fake := testCluster()
+	checkCollected(t, fake, collectFrom(t, fake, "web", false), []string{
+		"k8s-cluster cluster", "node-1 node", "node-2 node", "web namespace", "web/api-1 pod", "web/api-2 pod",
+		"web/api-1/app container", "web/api-1/proxy container", "web/api-2/app container",
+		"node-1/kubelet freecontainer", "node-2/kubelet freecontainer", "node-2/docker-daemon freecontainer",
+	})
+}
+
+func TestCollectAllNamespaces(t *testing.T) {
+	fake := testCluster()
+	checkCollected(t, fake, collectFrom(t, fake, "", true), []string{
+		"k8s-cluster cluster", "node-1 node", "node-2 node",
+		"db namespace", "idle namespace", "web namespace",
+		"db/pg-0 pod", "web/api-1 pod", "web/api-2 pod",
+		"db/pg-0/postgres container", "web/api-1/app container", "web/api-1/proxy container", "web/api-2/app container",
+		"node-1/kubelet freecontainer", "node-2/kubelet freecontainer", "node-2/docker-daemon freecontainer",
+	})
+}
+
+func TestCollectNamespaceWithoutPods(t *testing.T) {
+	fake := testCluster()
+	checkCollected(t, fake, collectFrom(t, fake, "idle", false), []string{
+		"k8s-cluster cluster", "node-1 node", "node-2 node", "idle namespace",
+		"node-1/kubelet freecontainer", "node-2/kubelet freecontainer", "node-2/docker-daemon freecontainer",
+	})
+}
+
+func TestCollectContainers(t *testing.T) {
+	fake := testCluster()
+	collected := collectFrom(t, fake, "web", false)
+	for name, want := range map[string]series.Labels{
+		"web/api-1/app container": {series.LabelCluster: "k8s-cluster", series.LabelNamespace: "web",
+			series.LabelPod: "api-1", series.LabelContainer: "app", series.LabelMetric: "cpu/usage_rate"},
+		"web/api-1/proxy container": {series.LabelCluster: "k8s-cluster", series.LabelNamespace: "web",
+			series.LabelPod: "api-1", series.LabelContainer: "proxy", series.LabelMetric: "cpu/usage_rate"},
+		"node-2/docker-daemon freecontainer": {series.LabelCluster: "k8s-cluster", series.LabelNode: "node-2",
+			series.LabelContainer: "docker-daemon", series.LabelMetric: "cpu/usage_rate"},
+	} {
+		s, ok := collected[name]
+		if !ok {
+			t.Errorf("%s: not collected", name)
+			continue
+		}
+		if !reflect.DeepEqual(s.Labels, want) {
+			t.Errorf("%s: got labels %v, want %v", name, s.Labels, want)
+		}
+	}
+}
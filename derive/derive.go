@@ -0,0 +1,114 @@
+package derive
+
+import (
+	"fmt"
+	"strings"
+
+	"../series"
+)
+
+// Metric is a derived metric: a name and the expression computing it
+type Metric struct {
+	Name string
+	Expr *Expr
+}
+
+// Builtin derived metrics, usable by name
+var Builtin = map[string]string{
+	"cpu/request_utilization":    "cpu/usage_rate / cpu/request * 100",
+	"cpu/limit_utilization":      "cpu/usage_rate / cpu/limit * 100",
+	"memory/request_utilization": "memory/usage / memory/request * 100",
+	"memory/limit_utilization":   "memory/usage / memory/limit * 100",
+}
+
+// ParseMetric parses a derived metric given as name=expression, as the name
+// of a builtin, or as a bare expression that is then also its name
+func ParseMetric(def string) (*Metric, error) {
+	name, text := def, def
+	if i := strings.Index(def, "="); i >= 0 {
+		name, text = strings.TrimSpace(def[:i]), def[i+1:]
+		if name == "" {
+			return nil, fmt.Errorf("missing name in %q", def)
+		}
+	} else if builtin, ok := Builtin[def]; ok {
+		text = builtin
+	}
+	expr, err := Parse(strings.TrimSpace(text))
+	if err != nil {
+		return nil, err
+	}
+	// Without a metric there is no entity to compute it for
+	if len(expr.Metrics()) == 0 {
+		return nil, fmt.Errorf("%q uses no metric", def)
+	}
+	return &Metric{Name: name, Expr: expr}, nil
+}
+
+// Apply computes the metric for every entity of set that has all the metrics
+// it uses. The derived series carry the labels of their entity, with the
+// metric label set to m.Name. Points where an operand is missing, or that
+// divide by zero, are missing.
+func (m *Metric) Apply(set series.Set) series.Set {
+	derived := make(series.Set, 0)
+	for _, group := range set.GroupBy(entityLabels(set)...) {
+		operands := make(map[string]*series.Series)
+		for _, s := range group.Set {
+			operands[s.Metric()] = s
+		}
+		complete := true
+		var grid series.Grid
+		for _, metric := range m.Expr.Metrics() {
+			s, ok := operands[metric]
+			if !ok {
+				complete = false
+				break
+			}
+			grid = s.Grid
+		}
+		if !complete {
+			continue
+		}
+
+		v := eval(m.Expr.root, operands, grid)
+		derived = append(derived, newSeries(group.Set[0].Labels.Copy(series.LabelMetric, m.Name), v, grid))
+	}
+	return derived
+}
+
+// Rate returns the per second rate of a cumulative series, as rate() in
+// expressions, with the labels of s
+func Rate(s *series.Series) *series.Series {
+	return newSeries(s.Labels, rate(value{values: s.Values, unit: s.Unit}, s.Grid), s.Grid)
+}
+
+// Returns the series of an evaluated value, with a point per value that is not missing
+func newSeries(labels series.Labels, v value, grid series.Grid) *series.Series {
+	s := &series.Series{
+		Labels: labels,
+		Unit:   v.unit,
+		Grid:   grid,
+		Values: v.values,
+	}
+	for i, value := range v.values {
+		if !series.IsMissing(value) {
+			s.Points = append(s.Points, series.Point{Time: grid.Time(i), Value: value})
+		}
+	}
+	return s
+}
+
+// Returns the names of all labels of set but the metric, which together
+// identify the entity (and aggregation) of a series
+func entityLabels(set series.Set) []string {
+	names := make([]string, 0)
+	seen := map[string]bool{series.LabelMetric: true}
+	for _, s := range set {
+		for name := range s.Labels {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
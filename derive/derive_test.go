@@ -0,0 +1,107 @@
+package derive
+
+import (
+	"reflect"
+	"testing"
+
+	"../series"
+	"../seriestest"
+)
+
+// A series of a metric of a pod of the web namespace
+func pod(name, metric string, values ...float64) *series.Series {
+	return seriestest.Aligned(seriestest.Pod("web", name, metric), values...)
+}
+
+func TestParse(t *testing.T) {
+	e, err := Parse("(cpu/usage_rate + 1) / cpu/request * 100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := e.Metrics(); !reflect.DeepEqual(got, []string{"cpu/usage_rate", "cpu/request"}) {
+		t.Errorf("metrics = %v", got)
+	}
+	for _, bad := range []string{"", "cpu/usage_rate /", "(cpu/request", "sqrt(cpu/request)", "cpu/request 100", "1.2.3"} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("Parse(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestParseMetric(t *testing.T) {
+	m, err := ParseMetric("memory/request_utilization")
+	if err != nil || m.Name != "memory/request_utilization" || !reflect.DeepEqual(m.Expr.Metrics(), []string{"memory/usage", "memory/request"}) {
+		t.Errorf("builtin = %+v, %v", m, err)
+	}
+	m, err = ParseMetric("rx = rate(network/rx)")
+	if err != nil || m.Name != "rx" {
+		t.Errorf("named = %+v, %v", m, err)
+	}
+	for _, bad := range []string{"x=5", "=cpu/usage_rate", "(1 + 2) * 3", "x=cpu/"} {
+		if _, err := ParseMetric(bad); err == nil {
+			t.Errorf("ParseMetric(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestApplyPercentOfRequest(t *testing.T) {
+	m := series.Missing()
+	set := series.Set{
+		pod("api-1", "cpu/usage_rate", 50, 100, m, 25),
+		pod("api-1", "cpu/request", 200, 200, 200, 0),
+		pod("api-2", "cpu/usage_rate", 1, 2, 3, 4),
+	}
+	metric, err := ParseMetric("cpu/request_utilization")
+	if err != nil {
+		t.Fatal(err)
+	}
+	derived := metric.Apply(set)
+	// api-2 has no cpu/request
+	if len(derived) != 1 {
+		t.Fatalf("got %d series, want 1", len(derived))
+	}
+	s := derived[0]
+	if s.Name() != "web/api-1" || s.Metric() != "cpu/request_utilization" || s.Unit != series.Percent {
+		t.Errorf("series %s %s in %q", s.Name(), s.Metric(), s.Unit)
+	}
+	if s.Values[0] != 25 || s.Values[1] != 50 || !series.IsMissing(s.Values[2]) || !series.IsMissing(s.Values[3]) {
+		t.Errorf("values = %v, want [25 50 NaN NaN]", s.Values)
+	}
+	if len(s.Points) != 2 {
+		t.Errorf("points = %v, want 2", s.Points)
+	}
+}
+
+func TestApplyRate(t *testing.T) {
+	m := series.Missing()
+	set := series.Set{pod("api-1", "network/rx", 600, 1200, m, 300)}
+	metric, err := ParseMetric("network/rx_derived=rate(network/rx)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := metric.Apply(set)[0]
+	if s.Metric() != "network/rx_derived" || s.Unit != series.BytesPerSecond {
+		t.Errorf("series %s in %q", s.Metric(), s.Unit)
+	}
+	// The counter was reset between 10:01 and 10:03, and counted 300 since
+	if !series.IsMissing(s.Values[0]) || s.Values[1] != 10 || !series.IsMissing(s.Values[2]) || s.Values[3] != 2.5 {
+		t.Errorf("values = %v, want [NaN 10 NaN 2.5]", s.Values)
+	}
+}
+
+func TestApplyCPUTimeRate(t *testing.T) {
+	// One core busy: a second of CPU time every second
+	set := series.Set{pod("api-1", "cpu/usage", 0, 60e9, 120e9, 180e9)}
+	metric, _ := ParseMetric("rate(cpu/usage)")
+	s := metric.Apply(set)[0]
+	if s.Metric() != "rate(cpu/usage)" || s.Unit != series.Millicores || s.Values[1] != 1000 {
+		t.Errorf("series %s = %v in %q, want 1000 millicores", s.Metric(), s.Values, s.Unit)
+	}
+}
+
+func TestRate(t *testing.T) {
+	s := Rate(pod("api-1", "network/rx", 600, 1200, 1800, 300))
+	if s.Metric() != "network/rx" || s.Unit != series.BytesPerSecond || s.Values[1] != 10 || s.Values[3] != 5 || len(s.Points) != 3 {
+		t.Errorf("series %s = %v in %q, want [NaN 10 10 5] bytes/s", s.Metric(), s.Values, s.Unit)
+	}
+}
@@ -0,0 +1,130 @@
+package derive
+
+import "../series"
+
+// The values of an evaluated node on the grid and their unit
+// A number has no values, only its constant
+type value struct {
+	values   []float64
+	constant float64
+	unit     series.Unit
+	isNumber bool
+	ratio    bool //quotient of two values of the same unit
+}
+
+func eval(n node, operands map[string]*series.Series, grid series.Grid) value {
+	switch n := n.(type) {
+	case *numberNode:
+		return value{constant: n.value, isNumber: true}
+	case *metricNode:
+		s := operands[n.metric]
+		return value{values: s.Values, unit: s.Unit}
+	case *callNode:
+		return rate(eval(n.arg, operands, grid), grid)
+	case *binaryNode:
+		return binary(n.op, eval(n.left, operands, grid), eval(n.right, operands, grid), grid)
+	}
+	panic("derive: unknown node")
+}
+
+func (v value) at(i int) float64 {
+	if v.isNumber {
+		return v.constant
+	}
+	return v.values[i]
+}
+
+func binary(op byte, l, r value, grid series.Grid) value {
+	if l.isNumber && r.isNumber {
+		return value{constant: apply(op, l.constant, r.constant), isNumber: true}
+	}
+	result := value{values: make([]float64, grid.Len), unit: binaryUnit(op, l, r)}
+	for i := range result.values {
+		result.values[i] = apply(op, l.at(i), r.at(i))
+	}
+	result.ratio = op == '/' && !l.isNumber && !r.isNumber && l.unit == r.unit
+	if result.ratio {
+		result.unit = series.None
+	}
+	return result
+}
+
+// Unit of a binary operation: scaling by a number keeps the unit, and a
+// ratio of two values of the same unit multiplied by 100 is a percentage
+func binaryUnit(op byte, l, r value) series.Unit {
+	switch {
+	case op == '*' && l.ratio && r.isNumber && r.constant == 100,
+		op == '*' && r.ratio && l.isNumber && l.constant == 100:
+		return series.Percent
+	case r.isNumber:
+		return l.unit
+	case l.isNumber && op == '*':
+		return r.unit
+	case (op == '+' || op == '-') && l.unit == r.unit:
+		return l.unit
+	}
+	return series.None
+}
+
+// Applies an operator, missing if an operand is missing or on division by zero
+func apply(op byte, a, b float64) float64 {
+	if series.IsMissing(a) || series.IsMissing(b) {
+		return series.Missing()
+	}
+	switch op {
+	case '+':
+		return a + b
+	case '-':
+		return a - b
+	case '*':
+		return a * b
+	}
+	if b == 0 {
+		return series.Missing()
+	}
+	return a / b
+}
+
+// Per second rate of a cumulative counter
+// The increase since the previous value is used, or the value itself when it
+// is lower than the previous one, as the counter was reset to 0 in between.
+// The first value, and values after a gap that follow nothing, are missing.
+func rate(v value, grid series.Grid) value {
+	result := value{values: make([]float64, grid.Len), unit: rateUnit(v.unit)}
+	scale := 1.0
+	if v.unit == series.Nanoseconds {
+		//CPU time per second, in millicores
+		scale = 1e-6
+	}
+	prev := -1
+	for i := range result.values {
+		result.values[i] = series.Missing()
+		current := v.at(i)
+		if series.IsMissing(current) {
+			continue
+		}
+		if prev >= 0 {
+			increase := current - v.at(prev)
+			if increase < 0 {
+				increase = current
+			}
+			seconds := grid.Time(i).Sub(grid.Time(prev)).Seconds()
+			result.values[i] = increase / seconds * scale
+		}
+		prev = i
+	}
+	return result
+}
+
+// Unit of the rate of a counter
+func rateUnit(unit series.Unit) series.Unit {
+	switch unit {
+	case series.Nanoseconds:
+		return series.Millicores
+	case series.Bytes:
+		return series.BytesPerSecond
+	case series.Count:
+		return series.CountPerSecond
+	}
+	return series.None
+}
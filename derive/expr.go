@@ -0,0 +1,211 @@
+// Derived metrics computed from collected series
+
+package derive
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expr is a parsed expression over metrics, such as
+// cpu/usage_rate / cpu/request * 100 or rate(network/rx)
+type Expr struct {
+	text string
+	root node
+}
+
+// A node of the expression tree
+type node interface{}
+
+type numberNode struct {
+	value float64
+}
+
+type metricNode struct {
+	metric string
+}
+
+type binaryNode struct {
+	op          byte
+	left, right node
+}
+
+type callNode struct {
+	fn  string
+	arg node
+}
+
+// Functions usable in expressions
+var functions = map[string]bool{
+	"rate": true,
+}
+
+// Parse parses an expression. Metric names may contain '/', so a division
+// must have spaces around it: cpu/usage_rate / cpu/request.
+func Parse(text string) (*Expr, error) {
+	p := &parser{text: text}
+	p.next()
+	root, err := p.expr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %v", text, err)
+	}
+	if p.tok != "" {
+		return nil, fmt.Errorf("invalid expression %q: unexpected %q", text, p.tok)
+	}
+	return &Expr{text: text, root: root}, nil
+}
+
+// String returns the expression as it was given
+func (e *Expr) String() string {
+	return e.text
+}
+
+// Metrics returns the metrics the expression uses, in order of appearance
+func (e *Expr) Metrics() []string {
+	metrics := make([]string, 0)
+	seen := make(map[string]bool)
+	var walk func(n node)
+	walk = func(n node) {
+		switch n := n.(type) {
+		case *metricNode:
+			if !seen[n.metric] {
+				seen[n.metric] = true
+				metrics = append(metrics, n.metric)
+			}
+		case *binaryNode:
+			walk(n.left)
+			walk(n.right)
+		case *callNode:
+			walk(n.arg)
+		}
+	}
+	walk(e.root)
+	return metrics
+}
+
+// Recursive descent parser over a stream of tokens
+type parser struct {
+	text string
+	pos  int
+	tok  string
+}
+
+// Reads the next token into p.tok, "" at the end of the text
+func (p *parser) next() {
+	for p.pos < len(p.text) && p.text[p.pos] == ' ' {
+		p.pos++
+	}
+	if p.pos >= len(p.text) {
+		p.tok = ""
+		return
+	}
+	start := p.pos
+	c := rune(p.text[p.pos])
+	switch {
+	case strings.ContainsRune("+-*/()", c):
+		p.pos++
+	case unicode.IsDigit(c) || c == '.':
+		for p.pos < len(p.text) && (unicode.IsDigit(rune(p.text[p.pos])) || p.text[p.pos] == '.') {
+			p.pos++
+		}
+	default:
+		//Metric names and functions: a '/' directly followed by a name character is part of the name
+		for p.pos < len(p.text) {
+			c := rune(p.text[p.pos])
+			if c == '/' && p.pos+1 < len(p.text) && isNameChar(rune(p.text[p.pos+1])) {
+				p.pos++
+				continue
+			}
+			if !isNameChar(c) {
+				break
+			}
+			p.pos++
+		}
+		if p.pos == start {
+			p.pos++
+		}
+	}
+	p.tok = p.text[start:p.pos]
+}
+
+func isNameChar(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '.'
+}
+
+// expr := term {("+" | "-") term}
+func (p *parser) expr() (node, error) {
+	left, err := p.term()
+	for err == nil && (p.tok == "+" || p.tok == "-") {
+		op := p.tok[0]
+		p.next()
+		var right node
+		right, err = p.term()
+		left = &binaryNode{op, left, right}
+	}
+	return left, err
+}
+
+// term := factor {("*" | "/") factor}
+func (p *parser) term() (node, error) {
+	left, err := p.factor()
+	for err == nil && (p.tok == "*" || p.tok == "/") {
+		op := p.tok[0]
+		p.next()
+		var right node
+		right, err = p.factor()
+		left = &binaryNode{op, left, right}
+	}
+	return left, err
+}
+
+// factor := number | metric | function "(" expr ")" | "(" expr ")" | "-" factor
+func (p *parser) factor() (node, error) {
+	tok := p.tok
+	switch {
+	case tok == "":
+		return nil, fmt.Errorf("unexpected end")
+	case tok == "(":
+		p.next()
+		n, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.next()
+		return n, nil
+	case tok == "-":
+		p.next()
+		n, err := p.factor()
+		return &binaryNode{'-', &numberNode{0}, n}, err
+	case unicode.IsDigit(rune(tok[0])) || tok[0] == '.':
+		value, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", tok)
+		}
+		p.next()
+		return &numberNode{value}, nil
+	case isNameChar(rune(tok[0])):
+		p.next()
+		if p.tok != "(" {
+			return &metricNode{tok}, nil
+		}
+		if !functions[tok] {
+			return nil, fmt.Errorf("unknown function %q", tok)
+		}
+		p.next()
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != ")" {
+			return nil, fmt.Errorf("missing ) after %s(", tok)
+		}
+		p.next()
+		return &callNode{tok, arg}, nil
+	}
+	return nil, fmt.Errorf("unexpected %q", tok)
+}
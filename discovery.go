@@ -0,0 +1,212 @@
+//Discovery of the metrics Heapster has available, and selection among them with -metrics
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"./derive"
+	"./heapster"
+)
+
+// Metric types to collect for each entity type
+type metricSelection struct {
+	cluster       []string
+	node          []string
+	namespace     []string
+	pod           []string
+	container     []string
+	freeContainer []string
+}
+
+// A compiled -metrics glob pattern
+type metricPattern struct {
+	glob string
+	re   *regexp.Regexp
+}
+
+// Parses a comma separated list of glob patterns, where * matches any
+// sequence of characters (including /) and ? matches a single character
+func parseMetricPatterns(list string) []metricPattern {
+	patterns := make([]metricPattern, 0)
+	for _, glob := range strings.Split(list, ",") {
+		glob = strings.TrimSpace(glob)
+		if glob == "" {
+			continue
+		}
+		expr := ""
+		for _, r := range glob {
+			switch r {
+			case '*':
+				expr += ".*"
+			case '?':
+				expr += "."
+			default:
+				expr += regexp.QuoteMeta(string(r))
+			}
+		}
+		patterns = append(patterns, metricPattern{glob, regexp.MustCompile("^" + expr + "$")})
+	}
+	return patterns
+}
+
+// Parses a ; separated list of derived metrics, each name=expression or a builtin name
+// Returns them with the patterns of the metrics they use, which are collected as well
+func parseDerived(list string) ([]*derive.Metric, []metricPattern, error) {
+	derived := make([]*derive.Metric, 0)
+	patterns := make([]metricPattern, 0)
+	names := make(map[string]bool)
+	for _, def := range strings.Split(list, ";") {
+		if strings.TrimSpace(def) == "" {
+			continue
+		}
+		m, err := derive.ParseMetric(strings.TrimSpace(def))
+		if err != nil {
+			return nil, nil, err
+		}
+		names[m.Name] = true
+		for _, metric := range m.Expr.Metrics() {
+			//Derived metrics may use earlier ones, which are not collected
+			if !names[metric] {
+				patterns = append(patterns, parseMetricPatterns(metric)...)
+			}
+		}
+		derived = append(derived, m)
+	}
+	return derived, patterns, nil
+}
+
+// Returns the available metrics matched by any of the patterns, in sorted order
+// matched records which patterns matched at least one metric
+func selectMetrics(available []string, patterns []metricPattern, matched map[string]bool) []string {
+	selected := make([]string, 0)
+	for _, metric := range available {
+		found := false
+		for _, p := range patterns {
+			if p.re.MatchString(metric) {
+				matched[p.glob] = true
+				found = true
+			}
+		}
+		if found {
+			selected = append(selected, metric)
+		}
+	}
+	sort.Strings(selected)
+	return selected
+}
+
+// An entity whose metrics listing discovers those of its type
+type discoveryCandidate struct {
+	name string
+	list func() ([]string, error)
+}
+
+// Queries the metrics listing endpoint of the first entity of each type
+// and selects the metrics to collect among them
+// Metrics listed are kept in available by entity type, and types already in
+// it are not queried again, so that polls only discover the types that had no
+// entities before. An entity whose listing fails, e.g. one gone since it was
+// listed, is recorded in failures and the next one of its type tried; only
+// a type none of whose entities can be listed fails the discovery. Entity
+// types without any entities are not queried and get no metrics.
+func discoverMetrics(ctx context.Context, client *heapster.Client, patterns []metricPattern, available map[string][]string, nodeNames []string,
+	namespaces []string, podNames map[string][]string, podContainers map[string][]containerRef, freeContainers []containerRef, failures *failureLog) (*metricSelection, error) {
+
+	list := func(entityType string, candidates []discoveryCandidate) error {
+		if _, ok := available[entityType]; ok {
+			return nil
+		}
+		var err error
+		for _, c := range candidates {
+			var metrics []string
+			if metrics, err = c.list(); err == nil {
+				available[entityType] = metrics
+				return nil
+			}
+			failures.add(c.name+" metrics", err)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot list the metrics of any %s: %v", entityType, err)
+		}
+		return nil
+	}
+
+	var nodes, frees, nss, pods, containers []discoveryCandidate
+	for _, nodeName := range nodeNames {
+		nodeName := nodeName
+		nodes = append(nodes, discoveryCandidate{nodeName, func() ([]string, error) { return client.ListNodeMetrics(ctx, nodeName) }})
+	}
+	for _, c := range freeContainers {
+		c := c
+		frees = append(frees, discoveryCandidate{c.parent + "/" + c.name, func() ([]string, error) {
+			return client.ListFreeContainerMetrics(ctx, c.parent, c.name)
+		}})
+	}
+	for _, ns := range namespaces {
+		ns := ns
+		nss = append(nss, discoveryCandidate{ns, func() ([]string, error) { return client.ListNamespaceMetrics(ctx, ns) }})
+		for _, pod := range podNames[ns] {
+			pod := pod
+			pods = append(pods, discoveryCandidate{ns + "/" + pod, func() ([]string, error) { return client.ListPodMetrics(ctx, ns, pod) }})
+		}
+		for _, c := range podContainers[ns] {
+			c := c
+			containers = append(containers, discoveryCandidate{ns + "/" + c.parent + "/" + c.name, func() ([]string, error) {
+				return client.ListPodContainerMetrics(ctx, ns, c.parent, c.name)
+			}})
+		}
+	}
+
+	if _, ok := available["cluster"]; !ok {
+		metrics, err := client.ListClusterMetrics(ctx)
+		if err != nil {
+			return nil, err
+		}
+		available["cluster"] = metrics
+	}
+	for _, byType := range []struct {
+		entityType string
+		candidates []discoveryCandidate
+	}{{"node", nodes}, {"free container", frees}, {"namespace", nss}, {"pod", pods}, {"container", containers}} {
+		if err := list(byType.entityType, byType.candidates); err != nil {
+			return nil, err
+		}
+	}
+
+	matched := make(map[string]bool)
+	selection := &metricSelection{
+		cluster:       selectMetrics(available["cluster"], patterns, matched),
+		node:          selectMetrics(available["node"], patterns, matched),
+		namespace:     selectMetrics(available["namespace"], patterns, matched),
+		pod:           selectMetrics(available["pod"], patterns, matched),
+		container:     selectMetrics(available["container"], patterns, matched),
+		freeContainer: selectMetrics(available["free container"], patterns, matched),
+	}
+
+	for _, p := range patterns {
+		if !matched[p.glob] {
+			return nil, fmt.Errorf("metric %q is not available from Heapster\n%s", p.glob, formatAvailable(available))
+		}
+	}
+	return selection, nil
+}
+
+// Lists the available metrics of each entity type, one entity type per line
+func formatAvailable(available map[string][]string) string {
+	str := "Available metrics:\n"
+	for _, entityType := range []string{"cluster", "node", "namespace", "pod", "container", "free container"} {
+		metrics, ok := available[entityType]
+		if !ok {
+			continue
+		}
+		sorted := append([]string(nil), metrics...)
+		sort.Strings(sorted)
+		str += fmt.Sprintf("  %s: %s\n", entityType, strings.Join(sorted, ", "))
+	}
+	return str
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"./heapster"
+)
+
+func TestSelectMetrics(t *testing.T) {
+	available := []string{"memory/usage", "cpu/usage_rate", "cpu/request", "network/rx"}
+	for _, c := range []struct {
+		patterns    string
+		want        []string
+		wantMatched []string
+	}{
+		{"cpu/*", []string{"cpu/request", "cpu/usage_rate"}, []string{"cpu/*"}},
+		// A glob and a derived operand matching the same metric are both matched
+		{"cpu/*,cpu/usage_rate", []string{"cpu/request", "cpu/usage_rate"}, []string{"cpu/*", "cpu/usage_rate"}},
+		{"memory/usage,network/tx", []string{"memory/usage"}, []string{"memory/usage"}},
+		{"", []string{}, []string{}},
+	} {
+		matched := make(map[string]bool)
+		got := selectMetrics(available, parseMetricPatterns(c.patterns), matched)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%q: selected %v, want %v", c.patterns, got, c.want)
+		}
+		gotMatched := []string{}
+		for _, p := range parseMetricPatterns(c.patterns) {
+			if matched[p.glob] {
+				gotMatched = append(gotMatched, p.glob)
+			}
+		}
+		if !reflect.DeepEqual(gotMatched, c.wantMatched) {
+			t.Errorf("%q: matched %v, want %v", c.patterns, gotMatched, c.wantMatched)
+		}
+	}
+}
+
+func TestParseMetricPatterns(t *testing.T) {
+	for _, c := range []struct {
+		list    string
+		globs   []string
+		match   []string
+		noMatch []string
+	}{
+		{" cpu/* , ,memory/usage", []string{"cpu/*", "memory/usage"},
+			[]string{"cpu/usage_rate", "cpu/node_utilization", "memory/usage"}, []string{"memory/usage_rate", "network/rx"}},
+		// * matches across slashes, ? a single character and dots are literal
+		{"*rx*,network/?x", []string{"*rx*", "network/?x"},
+			[]string{"network/rx_errors_rate", "network/tx"}, []string{"network/txx"}},
+		{"custom/http.requests", []string{"custom/http.requests"}, []string{"custom/http.requests"}, []string{"custom/httpXrequests"}},
+		{"", []string{}, nil, []string{"cpu/usage_rate"}},
+	} {
+		patterns := parseMetricPatterns(c.list)
+		globs := make([]string, len(patterns))
+		for i, p := range patterns {
+			globs[i] = p.glob
+		}
+		if !reflect.DeepEqual(globs, c.globs) {
+			t.Errorf("%q: got globs %v, want %v", c.list, globs, c.globs)
+		}
+		matches := func(metric string) bool {
+			for _, p := range patterns {
+				if p.re.MatchString(metric) {
+					return true
+				}
+			}
+			return false
+		}
+		for _, m := range c.match {
+			if !matches(m) {
+				t.Errorf("%q does not match %s", c.list, m)
+			}
+		}
+		for _, m := range c.noMatch {
+			if matches(m) {
+				t.Errorf("%q matches %s", c.list, m)
+			}
+		}
+	}
+}
+
+func TestDiscoverMetricsOnce(t *testing.T) {
+	var requests []string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, strings.TrimPrefix(r.URL.Path, "/api/v1/model"))
+		if strings.Contains(r.URL.Path, "/pods/api-1/") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`["cpu/usage_rate", "memory/usage"]`))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+	client := heapster.NewClient(server.URL)
+	patterns := parseMetricPatterns("cpu/*")
+	available := make(map[string][]string)
+	discover := func(pods ...string) *metricSelection {
+		failures := &failureLog{}
+		selection, err := discoverMetrics(context.Background(), client, patterns, available, []string{"node-1"},
+			[]string{"web"}, map[string][]string{"web": pods}, nil, nil, failures)
+		if err != nil {
+			t.Fatalf("pods %v: unexpected error: %v", pods, err)
+		}
+		return selection
+	}
+
+	// Without pods yet, pods get no metrics
+	selection := discover()
+	if len(selection.pod) != 0 || !reflect.DeepEqual(selection.node, []string{"cpu/usage_rate"}) {
+		t.Errorf("got %+v", selection)
+	}
+	want := []string{"/metrics/", "/nodes/node-1/metrics/", "/namespaces/web/metrics/"}
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("requested %v, want %v", requests, want)
+	}
+
+	// Later only pods are discovered, past the one that is gone
+	requests = nil
+	selection = discover("api-1", "api-2")
+	if !reflect.DeepEqual(selection.pod, []string{"cpu/usage_rate"}) {
+		t.Errorf("got %+v", selection)
+	}
+	want = []string{"/namespaces/web/pods/api-1/metrics/", "/namespaces/web/pods/api-2/metrics/"}
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("requested %v, want %v", requests, want)
+	}
+
+	requests = nil
+	discover("api-2")
+	if len(requests) != 0 {
+		t.Errorf("requested %v, want nothing", requests)
+	}
+}
+
+func TestDiscoverMetricsFailures(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Path, "/nodes/node-1/"):
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		case strings.Contains(r.URL.Path, "/namespaces/gone/"):
+			http.NotFound(w, r)
+		default:
+			w.Write([]byte(`["cpu/usage_rate"]`))
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+	client := heapster.NewClient(server.URL)
+	client.Retries = 0
+	patterns := parseMetricPatterns("cpu/usage_rate")
+
+	// A node failing with a 5xx is recorded and the next one listed
+	failures := &failureLog{}
+	selection, err := discoverMetrics(context.Background(), client, patterns, make(map[string][]string),
+		[]string{"node-1", "node-2"}, []string{"gone", "web"}, nil, nil, nil, failures)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(selection.node, []string{"cpu/usage_rate"}) || !reflect.DeepEqual(selection.namespace, []string{"cpu/usage_rate"}) {
+		t.Errorf("got %+v", selection)
+	}
+	if len(failures.failures) != 2 || failures.failures[0].what != "node-1 metrics" || failures.failures[1].what != "gone metrics" {
+		t.Errorf("failures = %v", failures.failures)
+	}
+
+	// Only a type none of whose entities can be listed fails
+	_, err = discoverMetrics(context.Background(), client, patterns, make(map[string][]string),
+		[]string{"node-1"}, []string{"web"}, nil, nil, nil, &failureLog{})
+	if err == nil || !strings.Contains(err.Error(), "any node") {
+		t.Errorf("got error %v, want one for the nodes", err)
+	}
+}
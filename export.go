@@ -0,0 +1,28 @@
+//CSV export of the collected series, with -csv
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"./export"
+	"./series"
+)
+
+// Writes set to -csv, resampled, filled and scaled like the charts: the series
+// of each metric and entity type share a unit, given in their column or rows
+func writeCSV(set series.Set, out *output) {
+	f, err := os.Create(*csvFile)
+	if err == nil {
+		err = export.WriteCSV(f, byMetricAndType(set, out.prepare), out.layout, out.fill == series.FillOmit)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Printf("Warning: cannot write %s: %v\n", *csvFile, err)
+		return
+	}
+	fmt.Printf("Wrote %d series to %s\n", len(set), *csvFile)
+}
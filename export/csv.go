@@ -0,0 +1,153 @@
+// Package export writes series for other tools to read
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"time"
+
+	"../series"
+)
+
+// Layout is the shape of a CSV export
+type Layout string
+
+// Layouts
+const (
+	// Wide has a timestamp column, then a column per series
+	Wide Layout = "wide"
+	// Long has a row per value, with the entity and metric it belongs to
+	Long Layout = "long"
+)
+
+// Layouts lists the valid layouts
+var Layouts = []Layout{Wide, Long}
+
+// ParseLayout returns the named layout
+func ParseLayout(name string) (Layout, error) {
+	for _, l := range Layouts {
+		if string(l) == name {
+			return l, nil
+		}
+	}
+	return "", fmt.Errorf("unknown CSV layout %q, want one of %v", name, Layouts)
+}
+
+// WriteCSV writes the values of set, all on the same grid, in the given layout
+// Missing values are written as empty cells, or left out when omit is set:
+// the timestamps no series has a value for in the wide layout, the rows of
+// missing values in the long one.
+func WriteCSV(w io.Writer, set series.Set, layout Layout, omit bool) error {
+	cw := csv.NewWriter(w)
+	var err error
+	if layout == Long {
+		err = writeLong(cw, set, omit)
+	} else {
+		err = writeWide(cw, set, omit)
+	}
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Header of a series in the wide layout, e.g. default/web-1 cpu/usage_rate (cores)
+func columnName(s *series.Series) string {
+	name := s.Name() + " " + s.Metric()
+	if s.Unit != series.None {
+		name += " (" + string(s.Unit) + ")"
+	}
+	return name
+}
+
+func writeWide(cw *csv.Writer, set series.Set, omit bool) error {
+	header := []string{"timestamp"}
+	for _, s := range set {
+		header = append(header, columnName(s))
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	if len(set) == 0 {
+		return nil
+	}
+
+	grid := set[0].Grid
+	for i, t := range grid.Times() {
+		row := []string{formatTime(t)}
+		present := false
+		for _, s := range set {
+			v := s.Values[i]
+			present = present || !series.IsMissing(v)
+			row = append(row, formatValue(v))
+		}
+		if omit && !present {
+			continue
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeLong(cw *csv.Writer, set series.Set, omit bool) error {
+	// The aggregation is only a column when some series are aggregations
+	aggregated := len(set.LabelValues(series.LabelAggregation)) > 0
+	header := []string{"timestamp", "entity_type", "namespace", "name", "container", "metric"}
+	if aggregated {
+		header = append(header, "aggregation")
+	}
+	header = append(header, "value", "unit")
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	for _, s := range set {
+		entity := []string{s.Labels.Type(), s.Labels[series.LabelNamespace], entityName(s.Labels), s.Labels[series.LabelContainer], s.Metric()}
+		if aggregated {
+			entity = append(entity, s.Labels[series.LabelAggregation])
+		}
+		for i, t := range s.Grid.Times() {
+			v := s.Values[i]
+			if omit && series.IsMissing(v) {
+				continue
+			}
+			row := append([]string{formatTime(t)}, entity...)
+			row = append(row, formatValue(v), string(s.Unit))
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Name of the entity a series belongs to in the long layout: its pod for
+// pods and their containers, its node for nodes and free containers, or
+// the namespace or cluster
+func entityName(labels series.Labels) string {
+	switch labels.Type() {
+	case series.TypePod, series.TypeContainer:
+		return labels[series.LabelPod]
+	case series.TypeNode, series.TypeFreeContainer:
+		return labels[series.LabelNode]
+	case series.TypeNamespace:
+		return labels[series.LabelNamespace]
+	}
+	return labels[series.LabelCluster]
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// Values in full precision, as in the Prometheus format, and missing ones as empty cells
+func formatValue(v float64) string {
+	if series.IsMissing(v) {
+		return ""
+	}
+	return formatFloat(v)
+}
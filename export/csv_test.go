@@ -0,0 +1,94 @@
+package export
+
+import (
+	"bytes"
+	"testing"
+
+	"../series"
+	"../seriestest"
+)
+
+var grid = seriestest.Grid(3)
+
+func testSet() series.Set {
+	m := series.Missing()
+	pod := seriestest.Aligned(seriestest.Pod("web", "api-1", "cpu/usage_rate"), 100, m, m)
+	// Scaled, as the series written are
+	container := seriestest.Aligned(pod.Labels.Copy(series.LabelContainer, "app", series.LabelMetric, "memory/usage"), 1.5, 2.25, m)
+	container.Unit = series.MiB
+	node := seriestest.Aligned(series.Labels{series.LabelCluster: "k8s-cluster", series.LabelNode: "node-1", series.LabelMetric: "cpu/usage_rate"}, m, 0.3333333, m)
+	return series.Set{pod, container, node}
+}
+
+func write(t *testing.T, set series.Set, layout Layout, omit bool) string {
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, set, layout, omit); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestWide(t *testing.T) {
+	want := `timestamp,web/api-1 cpu/usage_rate (millicores),web/api-1/app memory/usage (MiB),node-1 cpu/usage_rate (millicores)
+2016-05-23T10:00:00Z,100,1.5,
+2016-05-23T10:01:00Z,,2.25,0.3333333
+2016-05-23T10:02:00Z,,,
+`
+	if got := write(t, testSet(), Wide, false); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	// Only the timestamp without any value is left out
+	wantOmit := want[:len(want)-len("2016-05-23T10:02:00Z,,,\n")]
+	if got := write(t, testSet(), Wide, true); got != wantOmit {
+		t.Errorf("got\n%s\nwant\n%s", got, wantOmit)
+	}
+}
+
+func TestLong(t *testing.T) {
+	want := `timestamp,entity_type,namespace,name,container,metric,value,unit
+2016-05-23T10:00:00Z,pod,web,api-1,,cpu/usage_rate,100,millicores
+2016-05-23T10:00:00Z,container,web,api-1,app,memory/usage,1.5,MiB
+2016-05-23T10:01:00Z,container,web,api-1,app,memory/usage,2.25,MiB
+2016-05-23T10:01:00Z,node,,node-1,,cpu/usage_rate,0.3333333,millicores
+`
+	if got := write(t, testSet(), Long, true); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	// Without omit, every timestamp of every series has a row
+	if got := write(t, testSet(), Long, false); bytes.Count([]byte(got), []byte("\n")) != 1+9 {
+		t.Errorf("got\n%s\nwant 9 rows", got)
+	}
+}
+
+func TestLongAggregations(t *testing.T) {
+	set := testSet()[:1]
+	set[0].Labels[series.LabelAggregation] = "p95"
+	want := `timestamp,entity_type,namespace,name,container,metric,aggregation,value,unit
+2016-05-23T10:00:00Z,pod,web,api-1,,cpu/usage_rate,p95,100,millicores
+`
+	if got := write(t, set, Long, true); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestParseLayout(t *testing.T) {
+	if l, err := ParseLayout("long"); l != Long || err != nil {
+		t.Errorf("got %q, %v", l, err)
+	}
+	if _, err := ParseLayout("tall"); err == nil {
+		t.Errorf("no error for an unknown layout")
+	}
+}
+
+func TestFullPrecision(t *testing.T) {
+	s := seriestest.Aligned(series.Labels{series.LabelCluster: "k8s-cluster", series.LabelNode: "node-1", series.LabelMetric: "cpu/node_utilization"}, 0.00042, 3<<30, 1e20)
+	want := `timestamp,node-1 cpu/node_utilization (ratio)
+2016-05-23T10:00:00Z,0.00042
+2016-05-23T10:01:00Z,3221225472
+2016-05-23T10:02:00Z,1e+20
+`
+	if got := write(t, series.Set{s}, Wide, false); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
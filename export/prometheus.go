@@ -0,0 +1,222 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"../series"
+)
+
+// Prefix of the Prometheus name of every Heapster metric
+const MetricPrefix = "heapster_"
+
+// Format is a Prometheus exposition format
+type Format int
+
+// Formats
+const (
+	// Text is the Prometheus text format, version 0.0.4
+	Text Format = iota
+	// OpenMetrics is the OpenMetrics text format, version 1.0.0
+	OpenMetrics
+)
+
+// ContentType returns the Content-Type header of an exposition in format f
+func (f Format) ContentType() string {
+	if f == OpenMetrics {
+		return "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	}
+	return "text/plain; version=0.0.4; charset=utf-8"
+}
+
+// NegotiateFormat returns OpenMetrics if the Accept header of a scrape asks
+// for it, as Prometheus does, and the text format otherwise
+func NegotiateFormat(accept string) Format {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		if mediaType == "application/openmetrics-text" {
+			return OpenMetrics
+		}
+	}
+	return Text
+}
+
+// Sample is the latest value of a series
+type Sample struct {
+	Labels series.Labels
+	Unit   series.Unit
+	Time   time.Time
+	Value  float64
+}
+
+// Latest returns the latest value of each series of set that has one, taken
+// from its points as returned or, for derived series, from its grid
+func Latest(set series.Set) []Sample {
+	var samples []Sample
+	for _, s := range set {
+		sample := Sample{Labels: s.Labels, Unit: s.Unit, Value: series.Missing()}
+		for i := len(s.Points) - 1; i >= 0; i-- {
+			if p := s.Points[i]; !series.IsMissing(p.Value) {
+				sample.Time, sample.Value = p.Time, p.Value
+				break
+			}
+		}
+		if series.IsMissing(sample.Value) {
+			for i := len(s.Values) - 1; i >= 0; i-- {
+				if v := s.Values[i]; !series.IsMissing(v) {
+					sample.Time, sample.Value = s.Grid.Time(i), v
+					break
+				}
+			}
+		}
+		if !series.IsMissing(sample.Value) {
+			samples = append(samples, sample)
+		}
+	}
+	return samples
+}
+
+// MetricName returns the Prometheus name of a Heapster metric, with every
+// character not allowed in a name replaced by an underscore, e.g.
+// cpu/usage_rate is heapster_cpu_usage_rate
+func MetricName(metric string) string {
+	return MetricPrefix + sanitize(metric, true)
+}
+
+// LabelName returns name with every character not allowed in a Prometheus
+// label name replaced by an underscore, and one prepended to a leading digit
+func LabelName(name string) string {
+	name = sanitize(name, false)
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// Replaces the characters outside [a-zA-Z0-9_], and colons unless allowed
+func sanitize(s string, colons bool) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		case r == ':' && colons:
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	valueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// A metric family: the samples of every metric with the same Prometheus name
+type family struct {
+	name    string
+	metrics []string //Heapster metrics with this name
+	unit    series.Unit
+	samples []Sample
+}
+
+// WritePrometheus writes samples in format, a gauge per metric with the
+// metric label as its name and the other labels as they are
+// Families and their samples are sorted, and each sample has the time
+// Heapster took it at rather than the time of the scrape.
+func WritePrometheus(w io.Writer, samples []Sample, format Format) error {
+	families := make(map[string]*family)
+	var names []string
+	for _, s := range samples {
+		metric := s.Labels[series.LabelMetric]
+		name := MetricName(metric)
+		f, ok := families[name]
+		if !ok {
+			f = &family{name: name, unit: s.Unit}
+			families[name] = f
+			names = append(names, name)
+		}
+		if !contains(f.metrics, metric) {
+			f.metrics = append(f.metrics, metric)
+		}
+		f.samples = append(f.samples, s)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	for _, name := range names {
+		f := families[name]
+		sort.Strings(f.metrics)
+		help := "Heapster metric " + strings.Join(f.metrics, ", ")
+		if f.unit != series.None {
+			help += " in " + string(f.unit)
+		}
+		fmt.Fprintf(bw, "# HELP %s %s\n", name, helpEscaper.Replace(help))
+		fmt.Fprintf(bw, "# TYPE %s gauge\n", name)
+
+		lines := make([]string, len(f.samples))
+		for i, s := range f.samples {
+			lines[i] = name + formatLabels(s.Labels) + " " + formatFloat(s.Value) + " " + formatTimestamp(s.Time, format)
+		}
+		sort.Strings(lines)
+		for _, line := range lines {
+			bw.WriteString(line + "\n")
+		}
+	}
+	if format == OpenMetrics {
+		bw.WriteString("# EOF\n")
+	}
+	return bw.Flush()
+}
+
+// Labels of a sample without its metric, sorted, e.g. {namespace="web",pod="api-1"}
+func formatLabels(labels series.Labels) string {
+	var pairs []string
+	for name, value := range labels {
+		if name == series.LabelMetric || value == "" {
+			continue
+		}
+		pairs = append(pairs, LabelName(name)+`="`+valueEscaper.Replace(value)+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case v == math.Trunc(v) && math.Abs(v) < 1e15:
+		//Whole numbers such as bytes in full rather than with an exponent
+		return strconv.FormatFloat(v, 'f', 0, 64)
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Milliseconds in the text format, seconds in OpenMetrics
+func formatTimestamp(t time.Time, format Format) string {
+	ms := t.UnixNano() / int64(time.Millisecond)
+	if format == OpenMetrics {
+		return strconv.FormatFloat(float64(ms)/1000, 'f', -1, 64)
+	}
+	return strconv.FormatInt(ms, 10)
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package export
+
+import (
+	"bytes"
+	"testing"
+
+	"../series"
+)
+
+func TestLatest(t *testing.T) {
+	set := testSet()
+	// A derived series has no points, its latest value is on its grid
+	set[2].Points = nil
+	set[0].Points = []series.Point{{Time: grid.Time(0), Value: 100}, {Time: grid.Time(1), Value: series.Missing()}}
+	samples := Latest(set)
+	if len(samples) != 3 {
+		t.Fatalf("got %d samples, want 3: %v", len(samples), samples)
+	}
+	if s := samples[0]; s.Value != 100 || !s.Time.Equal(grid.Time(0)) {
+		t.Errorf("pod sample %+v", s)
+	}
+	if s := samples[1]; s.Value != 2.25 || !s.Time.Equal(grid.Time(1)) {
+		t.Errorf("container sample %+v", s)
+	}
+	if s := samples[2]; s.Value != 0.3333333 || !s.Time.Equal(grid.Time(1)) || s.Unit != series.Millicores {
+		t.Errorf("node sample %+v", s)
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	at := grid.Time(1)
+	samples := []Sample{
+		{Labels: series.Labels{series.LabelCluster: "c", series.LabelNode: "node-1", series.LabelMetric: "cpu/usage_rate"}, Unit: series.Millicores, Time: at, Value: 250},
+		{Labels: series.Labels{series.LabelCluster: "c", series.LabelNamespace: "web", series.LabelPod: `api"1`, series.LabelMetric: "cpu/usage_rate"}, Unit: series.Millicores, Time: at, Value: 0.5},
+		{Labels: series.Labels{series.LabelCluster: "c", series.LabelMetric: "memory/usage"}, Unit: series.Bytes, Time: at, Value: 1 << 30},
+	}
+	want := `# HELP heapster_cpu_usage_rate Heapster metric cpu/usage_rate in millicores
+# TYPE heapster_cpu_usage_rate gauge
+heapster_cpu_usage_rate{cluster="c",namespace="web",pod="api\"1"} 0.5 1463997660000
+heapster_cpu_usage_rate{cluster="c",node="node-1"} 250 1463997660000
+# HELP heapster_memory_usage Heapster metric memory/usage in bytes
+# TYPE heapster_memory_usage gauge
+heapster_memory_usage{cluster="c"} 1073741824 1463997660000
+`
+	var buf bytes.Buffer
+	if err := WritePrometheus(&buf, samples, Text); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	// OpenMetrics has timestamps in seconds and ends with # EOF
+	buf.Reset()
+	if err := WritePrometheus(&buf, samples[2:], OpenMetrics); err != nil {
+		t.Fatal(err)
+	}
+	wantOM := `# HELP heapster_memory_usage Heapster metric memory/usage in bytes
+# TYPE heapster_memory_usage gauge
+heapster_memory_usage{cluster="c"} 1073741824 1463997660
+# EOF
+`
+	if got := buf.String(); got != wantOM {
+		t.Errorf("got\n%s\nwant\n%s", got, wantOM)
+	}
+}
+
+func TestNames(t *testing.T) {
+	for metric, want := range map[string]string{
+		"cpu/usage_rate":             "heapster_cpu_usage_rate",
+		"filesystem/usage":           "heapster_filesystem_usage",
+		"custom/http.requests-total": "heapster_custom_http_requests_total",
+		"memory/request_utilization": "heapster_memory_request_utilization",
+	} {
+		if got := MetricName(metric); got != want {
+			t.Errorf("MetricName(%q) = %q, want %q", metric, got, want)
+		}
+	}
+	for name, want := range map[string]string{"pod": "pod", "app.kubernetes.io/name": "app_kubernetes_io_name", "1x": "_1x"} {
+		if got := LabelName(name); got != want {
+			t.Errorf("LabelName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	prometheus := "application/openmetrics-text;version=1.0.0,application/openmetrics-text;version=0.0.1;q=0.75,text/plain;version=0.0.4;q=0.5,*/*;q=0.1"
+	if f := NegotiateFormat(prometheus); f != OpenMetrics {
+		t.Errorf("got %v for %s", f, prometheus)
+	}
+	if f := NegotiateFormat("*/*"); f != Text || f.ContentType() != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("got %v for */*", f)
+	}
+}
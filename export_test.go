@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"./export"
+	"./resample"
+	"./series"
+	"./seriestest"
+)
+
+func TestWriteCSVScale(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(file string) { *csvFile = file }(*csvFile)
+	*csvFile = filepath.Join(dir, "out.csv")
+
+	cluster := series.Labels{series.LabelCluster: "k8s-cluster", series.LabelMetric: "memory/usage"}
+	set := series.Set{
+		seriestest.Aligned(cluster, 30<<30, 31<<30),
+		seriestest.Aligned(seriestest.Pod("web", "api-1", "memory/usage"), 3<<20, 3.5*(1<<20)),
+		seriestest.Aligned(seriestest.Pod("web", "api-2", "memory/usage"), 1<<20, 1<<19),
+		seriestest.Aligned(seriestest.Pod("web", "api-1", "cpu/usage_rate"), 1500, 2),
+	}
+	reducer, _ := resample.ParseReducer("avg")
+	out := &output{grid: seriestest.Grid(2), reducer: reducer, fill: series.FillNull, layout: export.Wide}
+	writeCSV(set, out)
+
+	// Pods are scaled apart from the cluster, and together per metric
+	want := `timestamp,k8s-cluster memory/usage (GiB),web/api-1 memory/usage (MiB),web/api-2 memory/usage (MiB),web/api-1 cpu/usage_rate (cores)
+2016-05-23T10:00:00Z,30,3,1,1.5
+2016-05-23T10:01:00Z,31,3.5,0.5,0.002
+`
+	got, err := ioutil.ReadFile(*csvFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,254 @@
+//Concurrent fetching of metrics on a bounded pool of workers
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"./heapster"
+	"./series"
+)
+
+// A single metric request, run by the worker pool
+type fetchJob struct {
+	result *heapster.MetricResult
+	err    error
+}
+
+// Collects tasks and runs them on a fixed number of workers
+// Tasks write their results to the place they were queued for, so results
+// are read back in a deterministic order regardless of which worker finished first
+type fetchPool struct {
+	workers int
+	tasks   []func()
+}
+
+func newFetchPool(workers int) *fetchPool {
+	if workers < 1 {
+		workers = 1
+	}
+	return &fetchPool{workers: workers}
+}
+
+// Queues a task to be run by run()
+func (p *fetchPool) add(task func()) {
+	p.tasks = append(p.tasks, task)
+}
+
+// Queues a metric request and returns its job, to read the result once run() is done
+func (p *fetchPool) addMetric(fetch func() (*heapster.MetricResult, error)) *fetchJob {
+	job := &fetchJob{}
+	p.add(func() {
+		job.result, job.err = fetch()
+	})
+	return job
+}
+
+// Runs all queued tasks and waits for them to finish
+func (p *fetchPool) run() {
+	ch := make(chan func())
+	var wg sync.WaitGroup
+	for i := 0; i < p.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range ch {
+				task()
+			}
+		}()
+	}
+	for _, task := range p.tasks {
+		ch <- task
+	}
+	close(ch)
+	wg.Wait()
+	p.tasks = nil
+}
+
+// An entity whose metrics are collected: the labels of its series,
+// and how to fetch one of its metrics
+type entity struct {
+	labels    series.Labels
+	fetch     func(metricType string) (*heapster.MetricResult, error)
+	aggregate func(aggregations []string, metricType string) (*heapster.MetricAggregationResult, error)
+}
+
+// Queues a job for every (metric type, entity) pair
+// Returns the jobs indexed [metric type][entity]
+func queueJobs(pool *fetchPool, metricTypes []string, entities []entity) [][]*fetchJob {
+	jobs := make([][]*fetchJob, len(metricTypes))
+	for i, metricType := range metricTypes {
+		metricType := metricType
+		for _, e := range entities {
+			fetch := e.fetch
+			jobs[i] = append(jobs[i], pool.addMetric(func() (*heapster.MetricResult, error) {
+				return fetch(metricType)
+			}))
+		}
+	}
+	return jobs
+}
+
+// An aggregation to collect: Heapster's name for it and its name in output
+type aggregation struct {
+	name  string
+	label string
+}
+
+// Short names accepted by -aggregations, besides Heapster's own names
+var aggregationLabels = map[string]string{
+	"average": "avg",
+	"maximum": "max",
+	"minimum": "min",
+	"median":  "median",
+	"count":   "count",
+	"50-perc": "p50",
+	"95-perc": "p95",
+	"99-perc": "p99",
+}
+
+// Parses a comma separated list of aggregations, by short or Heapster name
+func parseAggregations(list string) ([]aggregation, error) {
+	aggs := make([]aggregation, 0)
+	for _, a := range strings.Split(list, ",") {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			continue
+		}
+		found := false
+		for _, name := range heapster.Aggregations {
+			if a == name || a == aggregationLabels[name] {
+				aggs = append(aggs, aggregation{name, aggregationLabels[name]})
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown aggregation %q, valid: avg/max/min/median/count/p50/p95/p99", a)
+		}
+	}
+	return aggs, nil
+}
+
+// Queues one request for all aggregations of every (metric type, entity) pair
+// Each aggregation is stored as a separate series, with an aggregation label
+// Returns the jobs indexed [metric type][series] and the entities labelling each series
+func queueAggregationJobs(pool *fetchPool, metricTypes []string, entities []entity, aggs []aggregation) ([][]*fetchJob, []entity) {
+	names := make([]string, len(aggs))
+	expanded := make([]entity, 0, len(entities)*len(aggs))
+	for a, agg := range aggs {
+		names[a] = agg.name
+	}
+	for _, e := range entities {
+		for _, agg := range aggs {
+			expanded = append(expanded, entity{labels: e.labels.Copy(series.LabelAggregation, agg.label)})
+		}
+	}
+
+	jobs := make([][]*fetchJob, len(metricTypes))
+	for i, metricType := range metricTypes {
+		metricType := metricType
+		for _, e := range entities {
+			aggJobs := make([]*fetchJob, len(aggs))
+			for a := range aggs {
+				aggJobs[a] = &fetchJob{}
+			}
+			jobs[i] = append(jobs[i], aggJobs...)
+
+			aggregate := e.aggregate
+			pool.add(func() {
+				result, err := aggregate(names, metricType)
+				for a, job := range aggJobs {
+					if err != nil {
+						job.err = err
+						continue
+					}
+					points := result.Points(aggs[a].name)
+					job.result = &heapster.MetricResult{Metrics: points}
+					if len(points) > 0 {
+						job.result.LatestTimestamp = points[len(points)-1].Timestamp
+					}
+				}
+			})
+		}
+	}
+	return jobs, expanded
+}
+
+// Queues pod-list requests for the pods of a namespace, one per metric type
+// and chunk of pods whose request URL fits in maxURLLen
+// Returns a job per pod like queueJobs, indexed [metric type][pod]
+// from gives the start of the request for a metric of the k-th pod; a chunk
+// starts at the earliest start of its pods
+// A chunk whose request fails falls back to fetching its pods one by one,
+// so a pod that disappeared in the meantime fails on its own
+func queuePodListJobs(ctx context.Context, pool *fetchPool, client *heapster.Client, ns string, pods []string,
+	metricTypes []string, entities []entity, from func(k int, metricType string) time.Time, end time.Time, maxURLLen int) [][]*fetchJob {
+
+	jobs := make([][]*fetchJob, len(metricTypes))
+	for i, metricType := range metricTypes {
+		metricType := metricType
+		jobs[i] = make([]*fetchJob, len(pods))
+		for k := range pods {
+			jobs[i][k] = &fetchJob{}
+		}
+
+		offset := 0
+		for _, chunk := range client.PodListChunks(ns, pods, metricType, maxURLLen) {
+			chunk := chunk
+			chunkJobs := jobs[i][offset : offset+len(chunk)]
+			chunkEntities := entities[offset : offset+len(chunk)]
+			start := from(offset, metricType)
+			for k := offset + 1; k < offset+len(chunk); k++ {
+				if t := from(k, metricType); t.Before(start) {
+					start = t
+				}
+			}
+			offset += len(chunk)
+
+			pool.add(func() {
+				list, err := client.PodListMetric(ctx, ns, chunk, metricType, start, end)
+				if err == nil {
+					for k, job := range chunkJobs {
+						job.result = &list.Items[k]
+					}
+					return
+				}
+				for k, job := range chunkJobs {
+					job.result, job.err = chunkEntities[k].fetch(metricType)
+				}
+			})
+		}
+	}
+	return jobs
+}
+
+// A request that failed for one entity without aborting the collection
+type failure struct {
+	what string
+	err  error
+}
+
+// Records partial failures of a collection
+type failureLog struct {
+	failures []failure
+}
+
+func (l *failureLog) add(what string, err error) {
+	l.failures = append(l.failures, failure{what, err})
+}
+
+// Prints a summary of the failures, if there were any
+func (l *failureLog) print() {
+	if len(l.failures) == 0 {
+		return
+	}
+	fmt.Printf("\n\nPARTIAL FAILURES (%d)\n\n", len(l.failures))
+	for _, f := range l.failures {
+		fmt.Printf("%s: %v\n", f.what, f.err)
+	}
+}
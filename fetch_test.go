@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"./heapster"
+)
+
+// Result of a fetch with a single point of value v
+func pointResult(v float64) *heapster.MetricResult {
+	return &heapster.MetricResult{Metrics: []heapster.MetricPoint{{Value: v}}}
+}
+
+func TestFetchPoolOrder(t *testing.T) {
+	pool := newFetchPool(4)
+	entities := make([]entity, 6)
+	for k := range entities {
+		k := k
+		entities[k] = entity{fetch: func(metricType string) (*heapster.MetricResult, error) {
+			// Later entities finish first
+			time.Sleep(time.Duration(len(entities)-k) * time.Millisecond)
+			if metricType == "memory/usage" {
+				return pointResult(float64(100 + k)), nil
+			}
+			return pointResult(float64(k)), nil
+		}}
+	}
+	jobs := queueJobs(pool, []string{"cpu/usage_rate", "memory/usage"}, entities)
+	pool.run()
+
+	for i, base := range []float64{0, 100} {
+		if len(jobs[i]) != len(entities) {
+			t.Fatalf("metric %d: got %d jobs, want %d", i, len(jobs[i]), len(entities))
+		}
+		for k, job := range jobs[i] {
+			if job.err != nil || job.result.Metrics[0].Value != base+float64(k) {
+				t.Errorf("job [%d][%d]: got %v, %v, want %v", i, k, job.result, job.err, base+float64(k))
+			}
+		}
+	}
+}
+
+func TestFetchPoolPerHost(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		fmt.Fprint(w, `{"metrics": [], "latestTimestamp": "2016-05-23T10:00:00Z"}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	client := heapster.NewClient(server.URL)
+	client.MaxPerHost = 2
+	pool := newFetchPool(8)
+	var jobs []*fetchJob
+	for i := 0; i < 16; i++ {
+		jobs = append(jobs, pool.addMetric(func() (*heapster.MetricResult, error) {
+			return client.ClusterMetric(context.Background(), "cpu/usage_rate", time.Time{}, time.Time{})
+		}))
+	}
+	pool.run()
+
+	for i, job := range jobs {
+		if job.err != nil {
+			t.Errorf("job %d: %v", i, job.err)
+		}
+	}
+	if maxInFlight != 2 {
+		t.Errorf("%d requests in flight at most, want 2", maxInFlight)
+	}
+}
+
+func TestQueuePodListJobs(t *testing.T) {
+	base := time.Date(2016, 5, 23, 10, 0, 0, 0, time.UTC)
+	var mu sync.Mutex
+	starts := make(map[string]string)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		// e.g. /api/v1/model/namespaces/default/pod-list/pod-0,pod-1/metrics/cpu/usage_rate
+		rest := strings.TrimPrefix(r.URL.Path, "/api/v1/model/namespaces/default/pod-list/")
+		pods := strings.Split(rest[:strings.Index(rest, "/")], ",")
+		mu.Lock()
+		starts[strings.Join(pods, ",")] = r.URL.Query().Get("start")
+		mu.Unlock()
+		if pods[0] == "pod-2" {
+			http.NotFound(w, r)
+			return
+		}
+		var items []string
+		for _, pod := range pods {
+			items = append(items, `{"metrics": [{"value": `+strings.TrimPrefix(pod, "pod-")+`}]}`)
+		}
+		fmt.Fprintf(w, `{"items": [%s]}`, strings.Join(items, ","))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+	client := heapster.NewClient(server.URL)
+	client.Retries = 0
+
+	pods := []string{"pod-0", "pod-1", "pod-2", "pod-3", "pod-4"}
+	entities := make([]entity, len(pods))
+	for k := range entities {
+		k := k
+		entities[k].fetch = func(metricType string) (*heapster.MetricResult, error) {
+			if k == 3 {
+				return nil, fmt.Errorf("pod-3 is gone")
+			}
+			return pointResult(float64(100 + k)), nil
+		}
+	}
+	// Later pods have earlier starts, a chunk starts at the earliest of its pods
+	from := func(k int, metricType string) time.Time {
+		return base.Add(-time.Duration(k) * time.Minute)
+	}
+	// Room for exactly two pods and their separating comma
+	metricTypes := []string{"cpu/usage_rate", "memory/usage"}
+	maxLen := len(server.URL+"/api/v1/model/namespaces/default/pod-list/"+"/metrics/cpu/usage_rate") +
+		len("?start=2006-01-02T15%3A04%3A05-07%3A00&end=2006-01-02T15%3A04%3A05-07%3A00") + len("pod-0,pod-1")
+
+	pool := newFetchPool(4)
+	jobs := queuePodListJobs(context.Background(), pool, client, "default", pods, metricTypes, entities, from, base, maxLen)
+	pool.run()
+
+	// pod-2 and pod-3 are fetched one by one after their chunk fails
+	want := []float64{0, 1, 102, -1, 4}
+	for i := range metricTypes {
+		for k, job := range jobs[i] {
+			switch {
+			case want[k] < 0:
+				if job.err == nil {
+					t.Errorf("job [%d][%d]: got %v, want an error", i, k, job.result)
+				}
+			case job.err != nil:
+				t.Errorf("job [%d][%d]: %v", i, k, job.err)
+			case job.result.Metrics[0].Value != want[k]:
+				t.Errorf("job [%d][%d]: got %v, want %v", i, k, job.result.Metrics[0].Value, want[k])
+			}
+		}
+	}
+	for chunk, k := range map[string]int{"pod-0,pod-1": 1, "pod-2,pod-3": 3, "pod-4": 4} {
+		if want := from(k, "").Format(time.RFC3339); starts[chunk] != want {
+			t.Errorf("chunk %s starts at %q, want %q", chunk, starts[chunk], want)
+		}
+	}
+}
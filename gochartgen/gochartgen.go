@@ -3,8 +3,8 @@
 package gochartgen
 
 import "strings"
-import "strconv"
 import "os"
+import "../series"
 
 //Error check helper
 func check(e error) {
@@ -14,87 +14,38 @@ func check(e error) {
 }
 
 // Create a time series chart file to be drawn by gochart (https://github.com/zieckey/gochart)
-// yAxisData[line number][values]
-func CreateTimeSeriesChartFile(fileName string, chartType string, xAxisData []int, yAxisData [][]int, yAxisLineNames []string, yAxisText string){
-	str := "ChartType = " + chartType +"\n" + 
-	"Title = " + fileName + "\n"+
-	"SubTitle = \n"+
-	"\nXAxisNumbers = "
-	
-	//Append X axis numbers
-	for i, x := range xAxisData {
-		str += strconv.Itoa(x)
-		if i != len(xAxisData) - 1 {
-			str += ", "
-		} else {
-			str += "\n"	
-		}
+// Each series is a line named after it, xAxisLabels label the slots of their grid
+// The unit of the first series is appended to yAxisText
+func CreateSeriesChartFile(fileName string, chartType string, xAxisLabels []string, data []*series.Series, yAxisText string){
+	if len(data) > 0 && data[0].Unit != series.None {
+		yAxisText += " (" + string(data[0].Unit) + ")"
 	}
-
-	str += "\nYAxisText = " + yAxisText + "\n\n"
-
-	//Append each row/line of the y-axis data
-	for i, yRow := range yAxisData {
-		str += "Data|" + yAxisLineNames[i] + " = "
-		for j, y := range yRow {
-			str += strconv.Itoa(y)
-			if j != len(yAxisData[i]) - 1 {
-				str += ", "
-			} else {
-				str += "\n"	
-			}
-		}
+	names := make([]string, len(data))
+	rows := make([][]float64, len(data))
+	for i, s := range data {
+		names[i] = s.Name()
+		rows[i] = s.Values
 	}
-
-	//Write out this string to the chart file
-	path, err1 := os.Getwd()
-	check(err1)
-	//fmt.Printf("Path: %s\n", path)
-
-	toks := strings.Split(fileName, "/")
-	fileName = strings.Join(toks, "-")
-	
-	fh, err2 := os.Create(path + "/" + fileName + ".chart")
-	check(err2)
-
-	defer fh.Close()
-
-	_, err3 := fh.WriteString(str)
-    check(err3)
+	writeChartFile(fileName, chartType, xAxisLabels, names, rows, yAxisText)
 }
 
-
-
-// Create a time series chart file to be drawn by gochart (https://github.com/zieckey/gochart)
-// yAxisData[line number][values]
-func CreateTimeSeriesChartFileTS(fileName string, chartType string, xAxisTS []string, yAxisData [][]int, yAxisLineNames []string, yAxisText string){
+//Writes the chart file of CreateSeriesChartFile
+//yAxisData[line number][values]
+func writeChartFile(fileName string, chartType string, xAxisLabels []string, yAxisLineNames []string, yAxisData [][]float64, yAxisText string){
 	str := "ChartType = " + chartType +"\n" + 
 	"Title = " + fileName + "\n"+
 	"SubTitle = \n"+
-	"\nXAxisNumbers = "
-
-	for i, xTS := range xAxisTS {
-		str += xTS[len(xTS)-2:]
-		if i != len(xAxisTS) - 1 {
-			str += ", "
-		} else {
-			str += "\n"	
-		}
-	}
+	"\nXAxisNumbers = " + strings.Join(xAxisLabels, ", ") + "\n"
 
 	str += "\nYAxisText = " + yAxisText + "\n\n"
 
 	//Append each row/line of the y-axis data
 	for i, yRow := range yAxisData {
-		str += "Data|" + yAxisLineNames[i] + " = "
+		values := make([]string, len(yRow))
 		for j, y := range yRow {
-			str += strconv.Itoa(y)
-			if j != len(yAxisData[i]) - 1 {
-				str += ", "
-			} else {
-				str += "\n"	
-			}
+			values[j] = series.FormatValue(y) //null for missing, drawn by gochart as a gap
 		}
+		str += "Data|" + yAxisLineNames[i] + " = " + strings.Join(values, ", ") + "\n"
 	}
 
 	//Write out this string to the chart file
@@ -113,57 +64,3 @@ func CreateTimeSeriesChartFileTS(fileName string, chartType string, xAxisTS []st
 	_, err3 := fh.WriteString(str)
     check(err3)
 }
-
-
-
-
-
-
-//Float version
-func CreateTimeSeriesChartFileFloat(fileName string, chartType string, xAxisData []float64, yAxisData [][]float64, yAxisLineNames []string, yAxisText string){
-	str := "ChartType = " + chartType +"\n" + 
-	"Title = " + fileName + "\n"+
-	"SubTitle = \n"+
-	"\nXAxisNumbers = "
-	
-	//Append X axis numbers
-	for i, x := range xAxisData {
-		str += strconv.FormatFloat(x, 'f', 3, 64)
-		if i != len(xAxisData) - 1 {
-			str += ", "
-		} else {
-			str += "\n"	
-		}
-	}
-
-	str += "\nYAxisText = " + yAxisText + "\n\n"
-
-	//Append each row/line of the y-axis data
-	for i, yRow := range yAxisData {
-		str += "Data|" + yAxisLineNames[i] + " = "
-		for j, y := range yRow {
-			str += strconv.FormatFloat(y, 'f', 3, 64)
-			if j != len(yAxisData[i]) - 1 {
-				str += ", "
-			} else {
-				str += "\n"	
-			}
-		}
-	}
-
-	//Write out this string to the chart file
-	path, err1 := os.Getwd()
-	check(err1)
-	//fmt.Printf("Path: %s\n", path)
-
-	toks := strings.Split(fileName, "/")
-	fileName = strings.Join(toks, "-")
-	
-	fh, err2 := os.Create(path + "/" + fileName + ".chart")
-	check(err2)
-
-	defer fh.Close()
-
-	_, err3 := fh.WriteString(str)
-    check(err3)
-}
\ No newline at end of file
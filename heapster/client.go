@@ -0,0 +1,435 @@
+//Client for the Heapster model API
+
+package heapster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Path prefix of the model API, relative to the Heapster service URL
+const modelPrefix = "/api/v1/model"
+
+// Client fetches and decodes responses from a Heapster service
+type Client struct {
+	//URL of the Heapster service, e.g. through the kubectl proxy:
+	//http://localhost:8080/api/v1/namespaces/kube-system/services/heapster-custom/proxy
+	BaseURL string
+
+	HTTPClient *http.Client
+
+	//Maximum number of requests in flight to the same host, 0 for no limit
+	MaxPerHost int
+
+	//Time limit for each attempt at a request, 0 for no limit
+	Timeout time.Duration
+
+	//Number of times a request is retried after a temporary failure,
+	//waiting Backoff after the first failure and doubling up to MaxBackoff
+	Retries    int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	//Called with the URL and body of every response decoded, e.g. to keep them
+	//Requests run concurrently, so it must be safe for concurrent use
+	OnResponse func(url string, body []byte)
+
+	mu       sync.Mutex
+	hostSems map[string]chan struct{}
+}
+
+// NewClient returns a Client for the Heapster service at baseURL
+func NewClient(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: http.DefaultClient,
+		Timeout:    30 * time.Second,
+		Retries:    3,
+		Backoff:    500 * time.Millisecond,
+		MaxBackoff: 10 * time.Second,
+	}
+}
+
+// Joins path elements below the model API prefix, escaping each element
+func modelPath(elems ...string) string {
+	path := modelPrefix
+	for _, e := range elems {
+		path += "/" + url.PathEscape(e)
+	}
+	return path
+}
+
+// Waits for a free request slot for host and returns a function releasing it
+func (c *Client) acquire(ctx context.Context, host string) (func(), error) {
+	if c.MaxPerHost <= 0 {
+		return func() {}, nil
+	}
+	c.mu.Lock()
+	if c.hostSems == nil {
+		c.hostSems = make(map[string]chan struct{})
+	}
+	sem, ok := c.hostSems[host]
+	if !ok {
+		sem = make(chan struct{}, c.MaxPerHost)
+		c.hostSems[host] = sem
+	}
+	c.mu.Unlock()
+
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Get sends a GET request for path below BaseURL and decodes the JSON response
+// body into v, with the same limits, retries and errors as the model API calls
+// Lets other APIs behind the same server, such as the Kubernetes API, share the client
+func (c *Client) Get(ctx context.Context, path string, query url.Values, v interface{}) error {
+	return c.get(ctx, path, query, v)
+}
+
+// Sends a GET request for path and decodes the JSON response body into v
+// Temporary failures are retried with exponential backoff
+func (c *Client) get(ctx context.Context, path string, query url.Values, v interface{}) error {
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := c.getOnce(ctx, u, v)
+		if err == nil {
+			return nil
+		}
+		var herr *Error
+		if attempt >= c.Retries || !errors.As(err, &herr) || !herr.Temporary() {
+			return err
+		}
+		if err := sleep(ctx, c.backoff(attempt)); err != nil {
+			return &Error{URL: u, Err: err}
+		}
+	}
+}
+
+// Returns the delay before retry number attempt+1: Backoff doubled for each
+// attempt, capped at MaxBackoff, with random jitter of up to half the delay
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.Backoff
+	for i := 0; i < attempt && d < c.MaxBackoff; i++ {
+		d *= 2
+	}
+	if c.MaxBackoff > 0 && d > c.MaxBackoff {
+		d = c.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Waits for d, or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Makes a single attempt at a GET request, bounded by Timeout
+func (c *Client) getOnce(ctx context.Context, u string, v interface{}) error {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return &Error{URL: u, Err: err}
+	}
+	release, err := c.acquire(ctx, parsed.Host)
+	if err != nil {
+		return &Error{URL: u, Err: err}
+	}
+	defer release()
+
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return &Error{URL: u, Err: err}
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return &Error{URL: u, Err: err}
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return &Error{URL: u, StatusCode: resp.StatusCode, Err: fmt.Errorf("reading body: %w", err)}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		snippet := strings.TrimSpace(string(body))
+		if len(snippet) > 200 {
+			snippet = snippet[:200] + "..."
+		}
+		return &Error{URL: u, StatusCode: resp.StatusCode, Body: snippet}
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return &Error{URL: u, StatusCode: resp.StatusCode, Err: fmt.Errorf("decoding response: %w", err)}
+	}
+	if c.OnResponse != nil {
+		c.OnResponse(u, body)
+	}
+	return nil
+}
+
+// Fetches a list of entity names
+func (c *Client) list(ctx context.Context, path string) ([]string, error) {
+	names := make([]string, 0)
+	if err := c.get(ctx, path+"/", nil, &names); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// Returns the query parameters for the interval [start, end]
+// A zero start or end is left out of the request
+func intervalQuery(start, end time.Time) url.Values {
+	query := url.Values{}
+	if !start.IsZero() {
+		query.Set("start", start.Format(time.RFC3339))
+	}
+	if !end.IsZero() {
+		query.Set("end", end.Format(time.RFC3339))
+	}
+	return query
+}
+
+// Fetches the metric at path for the interval [start, end]
+func (c *Client) metric(ctx context.Context, path string, start, end time.Time) (*MetricResult, error) {
+	result := &MetricResult{}
+	if err := c.get(ctx, path, intervalQuery(start, end), result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Fetches aggregations of a metric below the entity at path for the interval
+// [start, end], in buckets of the given size (Heapster's default if 0)
+func (c *Client) aggregation(ctx context.Context, path string, aggregations []string, metric string,
+	bucket time.Duration, start, end time.Time) (*MetricAggregationResult, error) {
+
+	query := intervalQuery(start, end)
+	if bucket > 0 {
+		query.Set("bucket", bucketParam(bucket))
+	}
+	result := &MetricAggregationResult{}
+	path += "/metrics-aggregated/" + strings.Join(aggregations, ",") + "/" + metric
+	if err := c.get(ctx, path, query, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Formats a bucket size as Heapster parses it: a whole number of d, h, m or
+// s, e.g. 5m or 90s, rounded up to a second
+func bucketParam(bucket time.Duration) string {
+	secs := int64((bucket + time.Second - 1) / time.Second)
+	for _, u := range []struct {
+		suffix string
+		secs   int64
+	}{{"d", 86400}, {"h", 3600}, {"m", 60}} {
+		if secs%u.secs == 0 {
+			return strconv.FormatInt(secs/u.secs, 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(secs, 10) + "s"
+}
+
+// ListNodes returns the names of all nodes known to Heapster
+func (c *Client) ListNodes(ctx context.Context) ([]string, error) {
+	return c.list(ctx, modelPath("nodes"))
+}
+
+// ListNamespaces returns the names of all namespaces known to Heapster
+func (c *Client) ListNamespaces(ctx context.Context) ([]string, error) {
+	return c.list(ctx, modelPath("namespaces"))
+}
+
+// ListPods returns the names of all pods in a namespace
+func (c *Client) ListPods(ctx context.Context, namespace string) ([]string, error) {
+	return c.list(ctx, modelPath("namespaces", namespace, "pods"))
+}
+
+// ListPodContainers returns the names of all containers of a pod
+func (c *Client) ListPodContainers(ctx context.Context, namespace, pod string) ([]string, error) {
+	return c.list(ctx, modelPath("namespaces", namespace, "pods", pod, "containers"))
+}
+
+// ListFreeContainers returns the names of the system containers of a node,
+// such as kubelet and docker-daemon, which do not belong to any pod
+func (c *Client) ListFreeContainers(ctx context.Context, node string) ([]string, error) {
+	return c.list(ctx, modelPath("nodes", node, "freecontainers"))
+}
+
+// ListClusterMetrics returns the names of the metrics available for the cluster
+func (c *Client) ListClusterMetrics(ctx context.Context) ([]string, error) {
+	return c.list(ctx, modelPath("metrics"))
+}
+
+// ListNodeMetrics returns the names of the metrics available for a node
+func (c *Client) ListNodeMetrics(ctx context.Context, node string) ([]string, error) {
+	return c.list(ctx, modelPath("nodes", node, "metrics"))
+}
+
+// ListNamespaceMetrics returns the names of the metrics available for a namespace
+func (c *Client) ListNamespaceMetrics(ctx context.Context, namespace string) ([]string, error) {
+	return c.list(ctx, modelPath("namespaces", namespace, "metrics"))
+}
+
+// ListPodMetrics returns the names of the metrics available for a pod
+func (c *Client) ListPodMetrics(ctx context.Context, namespace, pod string) ([]string, error) {
+	return c.list(ctx, modelPath("namespaces", namespace, "pods", pod, "metrics"))
+}
+
+// ListPodContainerMetrics returns the names of the metrics available for a container of a pod
+func (c *Client) ListPodContainerMetrics(ctx context.Context, namespace, pod, container string) ([]string, error) {
+	return c.list(ctx, modelPath("namespaces", namespace, "pods", pod, "containers", container, "metrics"))
+}
+
+// ListFreeContainerMetrics returns the names of the metrics available for a free container of a node
+func (c *Client) ListFreeContainerMetrics(ctx context.Context, node, container string) ([]string, error) {
+	return c.list(ctx, modelPath("nodes", node, "freecontainers", container, "metrics"))
+}
+
+// ClusterMetric returns a cluster-wide metric over [start, end]
+func (c *Client) ClusterMetric(ctx context.Context, metric string, start, end time.Time) (*MetricResult, error) {
+	return c.metric(ctx, modelPrefix+"/metrics/"+metric, start, end)
+}
+
+// NodeMetric returns a metric of a node over [start, end]
+func (c *Client) NodeMetric(ctx context.Context, node, metric string, start, end time.Time) (*MetricResult, error) {
+	return c.metric(ctx, modelPath("nodes", node, "metrics")+"/"+metric, start, end)
+}
+
+// NamespaceMetric returns a metric aggregated over all pods of a namespace over [start, end]
+func (c *Client) NamespaceMetric(ctx context.Context, namespace, metric string, start, end time.Time) (*MetricResult, error) {
+	return c.metric(ctx, modelPath("namespaces", namespace, "metrics")+"/"+metric, start, end)
+}
+
+// PodMetric returns a metric of a pod over [start, end]
+func (c *Client) PodMetric(ctx context.Context, namespace, pod, metric string, start, end time.Time) (*MetricResult, error) {
+	return c.metric(ctx, modelPath("namespaces", namespace, "pods", pod, "metrics")+"/"+metric, start, end)
+}
+
+// PodContainerMetric returns a metric of a container of a pod over [start, end]
+func (c *Client) PodContainerMetric(ctx context.Context, namespace, pod, container, metric string, start, end time.Time) (*MetricResult, error) {
+	return c.metric(ctx, modelPath("namespaces", namespace, "pods", pod, "containers", container, "metrics")+"/"+metric, start, end)
+}
+
+// FreeContainerMetric returns a metric of a system container of a node over [start, end]
+func (c *Client) FreeContainerMetric(ctx context.Context, node, container, metric string, start, end time.Time) (*MetricResult, error) {
+	return c.metric(ctx, modelPath("nodes", node, "freecontainers", container, "metrics")+"/"+metric, start, end)
+}
+
+// PodListMetric returns a metric of several pods of a namespace over [start, end]
+// in a single request, with one result per pod in the order of pods
+func (c *Client) PodListMetric(ctx context.Context, namespace string, pods []string, metric string, start, end time.Time) (*MetricResultList, error) {
+	result := &MetricResultList{}
+	if err := c.get(ctx, podListPath(namespace, pods)+"/metrics/"+metric, intervalQuery(start, end), result); err != nil {
+		return nil, err
+	}
+	if len(result.Items) != len(pods) {
+		return nil, fmt.Errorf("heapster: pod-list request for %d pods in %s returned %d results", len(pods), namespace, len(result.Items))
+	}
+	return result, nil
+}
+
+// Returns the model API path of a list of pods, /namespaces/{ns}/pod-list/{pod1,pod2,...}
+func podListPath(namespace string, pods []string) string {
+	escaped := make([]string, len(pods))
+	for i, pod := range pods {
+		escaped[i] = url.PathEscape(pod)
+	}
+	return modelPath("namespaces", namespace, "pod-list") + "/" + strings.Join(escaped, ",")
+}
+
+// PodListChunks splits pods into batches for PodListMetric whose request URLs
+// for metric stay within maxLen characters. A pod whose name alone exceeds
+// the limit gets a batch of its own.
+func (c *Client) PodListChunks(namespace string, pods []string, metric string, maxLen int) [][]string {
+	//Room for the start and end parameters with a numeric time zone offset
+	const queryLen = len("?start=2006-01-02T15%3A04%3A05-07%3A00&end=2006-01-02T15%3A04%3A05-07%3A00")
+	fixed := len(c.BaseURL) + len(podListPath(namespace, nil)) + len("/metrics/") + len(metric) + queryLen
+
+	chunks := make([][]string, 0)
+	chunk := make([]string, 0)
+	length := fixed
+	for _, pod := range pods {
+		podLen := len(url.PathEscape(pod))
+		if len(chunk) > 0 {
+			podLen++ //separating comma
+		}
+		if len(chunk) > 0 && length+podLen > maxLen {
+			chunks = append(chunks, chunk)
+			chunk = make([]string, 0)
+			length = fixed
+			podLen--
+		}
+		chunk = append(chunk, pod)
+		length += podLen
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// ClusterAggregation returns aggregations of a cluster-wide metric over
+// [start, end] in buckets of the given size
+func (c *Client) ClusterAggregation(ctx context.Context, aggregations []string, metric string, bucket time.Duration, start, end time.Time) (*MetricAggregationResult, error) {
+	return c.aggregation(ctx, modelPrefix, aggregations, metric, bucket, start, end)
+}
+
+// NodeAggregation returns aggregations of a metric of a node
+func (c *Client) NodeAggregation(ctx context.Context, node string, aggregations []string, metric string, bucket time.Duration, start, end time.Time) (*MetricAggregationResult, error) {
+	return c.aggregation(ctx, modelPath("nodes", node), aggregations, metric, bucket, start, end)
+}
+
+// NamespaceAggregation returns aggregations of a metric of a namespace
+func (c *Client) NamespaceAggregation(ctx context.Context, namespace string, aggregations []string, metric string, bucket time.Duration, start, end time.Time) (*MetricAggregationResult, error) {
+	return c.aggregation(ctx, modelPath("namespaces", namespace), aggregations, metric, bucket, start, end)
+}
+
+// PodAggregation returns aggregations of a metric of a pod
+func (c *Client) PodAggregation(ctx context.Context, namespace, pod string, aggregations []string, metric string, bucket time.Duration, start, end time.Time) (*MetricAggregationResult, error) {
+	return c.aggregation(ctx, modelPath("namespaces", namespace, "pods", pod), aggregations, metric, bucket, start, end)
+}
+
+// PodContainerAggregation returns aggregations of a metric of a container of a pod
+func (c *Client) PodContainerAggregation(ctx context.Context, namespace, pod, container string, aggregations []string, metric string, bucket time.Duration, start, end time.Time) (*MetricAggregationResult, error) {
+	return c.aggregation(ctx, modelPath("namespaces", namespace, "pods", pod, "containers", container), aggregations, metric, bucket, start, end)
+}
+
+// FreeContainerAggregation returns aggregations of a metric of a free container of a node
+func (c *Client) FreeContainerAggregation(ctx context.Context, node, container string, aggregations []string, metric string, bucket time.Duration, start, end time.Time) (*MetricAggregationResult, error) {
+	return c.aggregation(ctx, modelPath("nodes", node, "freecontainers", container), aggregations, metric, bucket, start, end)
+}
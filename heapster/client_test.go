@@ -0,0 +1,284 @@
+package heapster
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPodMetric(t *testing.T) {
+	var gotPath, gotStart string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotStart = r.URL.Query().Get("start")
+		// Fields out of order, a float value and an unknown field
+		w.Write([]byte(`{
+		  "latestTimestamp": "2016-05-23T10:01:00Z",
+		  "metrics": [
+		    {"value": 12, "timestamp": "2016-05-23T10:00:00Z"},
+		    {"timestamp": "2016-05-23T10:01:00Z", "extra": true, "value": 13.5}
+		  ]
+		}`))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	start := time.Date(2016, 5, 23, 10, 0, 0, 0, time.FixedZone("", 2*3600))
+	result, err := NewClient(server.URL).PodMetric(context.Background(), "default", "web-1", "cpu/usage_rate", start, time.Time{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := "/api/v1/model/namespaces/default/pods/web-1/metrics/cpu/usage_rate"; gotPath != want {
+		t.Errorf("path = %q, want %q", gotPath, want)
+	}
+	if want := "2016-05-23T10:00:00+02:00"; gotStart != want {
+		t.Errorf("start = %q, want %q", gotStart, want)
+	}
+	if len(result.Metrics) != 2 {
+		t.Fatalf("got %d points, want 2", len(result.Metrics))
+	}
+	if result.Metrics[1].Value != 13.5 {
+		t.Errorf("value = %v, want 13.5", result.Metrics[1].Value)
+	}
+	if !result.LatestTimestamp.Equal(result.Metrics[1].Timestamp) {
+		t.Errorf("latestTimestamp = %v, want %v", result.LatestTimestamp, result.Metrics[1].Timestamp)
+	}
+}
+
+func TestListNodes(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/model/nodes/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`["node-1", "node-2"]`))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	nodes, err := NewClient(server.URL).ListNodes(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(nodes) != 2 || nodes[0] != "node-1" || nodes[1] != "node-2" {
+		t.Errorf("nodes = %v", nodes)
+	}
+}
+
+func TestOnResponse(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`["node-1"]`))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	var urls, bodies []string
+	client := NewClient(server.URL)
+	client.OnResponse = func(url string, body []byte) {
+		urls = append(urls, url)
+		bodies = append(bodies, string(body))
+	}
+	if _, err := client.ListNodes(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(urls) != 1 || urls[0] != server.URL+"/api/v1/model/nodes/" || bodies[0] != `["node-1"]` {
+		t.Errorf("responses = %v %v", urls, bodies)
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html>Service Unavailable</html>`))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	if _, err := NewClient(server.URL).ClusterMetric(context.Background(), "cpu/usage_rate", time.Time{}, time.Time{}); err == nil {
+		t.Fatal("expected an error for a non-JSON response")
+	}
+}
+
+func TestRetryTemporaryFailure(t *testing.T) {
+	var count int
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		count++
+		if count < 3 {
+			http.Error(w, "proxy error", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`["default"]`))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	client.Backoff = time.Millisecond
+	namespaces, err := client.ListNamespaces(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count != 3 || len(namespaces) != 1 {
+		t.Errorf("got %v after %d requests, want [default] after 3", namespaces, count)
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	var count int
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		count++
+		http.NotFound(w, r)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	client.Backoff = time.Millisecond
+	_, err := client.ListPods(context.Background(), "gone")
+	if err == nil {
+		t.Fatal("expected an error for a 404 response")
+	}
+	herr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("error is %T, want *Error", err)
+	}
+	if herr.StatusCode != http.StatusNotFound || herr.URL != server.URL+"/api/v1/model/namespaces/gone/pods/" {
+		t.Errorf("StatusCode = %d, URL = %q", herr.StatusCode, herr.URL)
+	}
+	if !IsNotFound(err) {
+		t.Error("IsNotFound() = false")
+	}
+	if IsForbidden(err) {
+		t.Error("IsForbidden() = true for a 404")
+	}
+	if !IsForbidden(&Error{StatusCode: http.StatusForbidden}) || !IsForbidden(&Error{StatusCode: http.StatusUnauthorized}) {
+		t.Error("IsForbidden() = false for a 401 or 403")
+	}
+	if count != 1 {
+		t.Errorf("404 was requested %d times, want no retries", count)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	client.Timeout = 10 * time.Millisecond
+	client.Retries = 1
+	client.Backoff = time.Millisecond
+	_, err := client.ListNodes(context.Background())
+	herr, ok := err.(*Error)
+	if !ok || !herr.Temporary() {
+		t.Fatalf("got %v, want a temporary *Error", err)
+	}
+}
+
+func TestPodListMetric(t *testing.T) {
+	var gotPath string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		w.Write([]byte(`{"items": [
+		  {"metrics": [{"timestamp": "2016-05-23T10:00:00Z", "value": 1}]},
+		  {"metrics": [{"timestamp": "2016-05-23T10:00:00Z", "value": 2}]}
+		]}`))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	result, err := client.PodListMetric(context.Background(), "default", []string{"web-1", "web-2"}, "cpu/usage_rate", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "/api/v1/model/namespaces/default/pod-list/web-1,web-2/metrics/cpu/usage_rate"; gotPath != want {
+		t.Errorf("path = %q, want %q", gotPath, want)
+	}
+	if result.Items[1].Metrics[0].Value != 2 {
+		t.Errorf("second pod value = %v, want 2", result.Items[1].Metrics[0].Value)
+	}
+
+	if _, err := client.PodListMetric(context.Background(), "default", []string{"web-1"}, "cpu/usage_rate", time.Time{}, time.Time{}); err == nil {
+		t.Error("expected an error when the number of results does not match the pods")
+	}
+}
+
+func TestPodListChunks(t *testing.T) {
+	client := NewClient("http://localhost:8080")
+	pods := []string{"aaaa", "bbbb", "cccc", "dddd", "eeee"}
+	fixed := len(client.BaseURL+podListPath("default", nil)+"/metrics/cpu/usage_rate") +
+		len("?start=2006-01-02T15%3A04%3A05-07%3A00&end=2006-01-02T15%3A04%3A05-07%3A00")
+
+	// Room for exactly two pods and their separating comma
+	chunks := client.PodListChunks("default", pods, "cpu/usage_rate", fixed+9)
+	if len(chunks) != 3 || len(chunks[0]) != 2 || len(chunks[2]) != 1 {
+		t.Errorf("chunks = %v, want pods in twos", chunks)
+	}
+
+	// Too little room for any pod still makes progress
+	chunks = client.PodListChunks("default", pods, "cpu/usage_rate", 10)
+	if len(chunks) != len(pods) {
+		t.Errorf("chunks = %v, want one pod each", chunks)
+	}
+}
+
+func TestBucketParam(t *testing.T) {
+	for bucket, want := range map[time.Duration]string{
+		time.Minute:             "1m",
+		5 * time.Minute:         "5m",
+		90 * time.Second:        "90s",
+		2 * time.Hour:           "2h",
+		24 * time.Hour:          "1d",
+		1500 * time.Millisecond: "2s",
+	} {
+		if got := bucketParam(bucket); got != want {
+			t.Errorf("bucketParam(%v) = %q, want %q", bucket, got, want)
+		}
+	}
+}
+
+func TestPodAggregation(t *testing.T) {
+	var gotPath, gotBucket string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotBucket = r.URL.Query().Get("bucket")
+		w.Write([]byte(`{"bucketSize": 60000000000, "buckets": [
+		  {"timestamp": "2016-05-23T10:00:00Z", "count": 4, "average": 10.5, "percentiles": {"95": 20}},
+		  {"timestamp": "2016-05-23T10:01:00Z", "count": 0}
+		]}`))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	result, err := NewClient(server.URL).PodAggregation(context.Background(), "default", "web-1",
+		[]string{"average", "95-perc"}, "cpu/usage_rate", time.Minute, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "/api/v1/model/namespaces/default/pods/web-1/metrics-aggregated/average,95-perc/cpu/usage_rate"; gotPath != want {
+		t.Errorf("path = %q, want %q", gotPath, want)
+	}
+	if gotBucket != "1m" || result.BucketSize != time.Minute {
+		t.Errorf("bucket = %q, bucketSize = %v", gotBucket, result.BucketSize)
+	}
+
+	avg := result.Points("average")
+	if len(avg) != 1 || avg[0].Value != 10.5 {
+		t.Errorf("average points = %v, want one point of 10.5", avg)
+	}
+	p95 := result.Points("95-perc")
+	if len(p95) != 1 || p95[0].Value != 20 {
+		t.Errorf("95-perc points = %v, want one point of 20", p95)
+	}
+	if count := result.Points("count"); len(count) != 2 {
+		t.Errorf("count points = %v, want two", count)
+	}
+}
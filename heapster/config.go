@@ -0,0 +1,185 @@
+// Connection settings for reaching Heapster through the Kubernetes API server
+
+package heapster
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Config describes how to reach the Heapster service
+type Config struct {
+	// Address of the Kubernetes API server, e.g. http://localhost:8080 for a
+	// local kubectl proxy or https://10.0.0.1:6443 for direct access
+	APIServer string
+
+	// Namespace, name and port of the Heapster service. Port may be empty,
+	// a port number or a port name.
+	Namespace string
+	Service   string
+	Port      string
+
+	// URL of the Heapster service itself. When set the API server proxy is
+	// not used and the fields above are ignored.
+	URL string
+
+	// Authentication against the API server
+	BearerToken string
+	Username    string
+	Password    string
+
+	// Client certificate and key, as PEM data or as file paths
+	CertData []byte
+	KeyData  []byte
+	CertFile string
+	KeyFile  string
+
+	// Certificate authority used to verify the API server, as PEM data or a file path
+	CAData []byte
+	CAFile string
+
+	Insecure bool
+}
+
+// DefaultConfig returns a Config for the heapster-custom service in
+// kube-system, reached through a kubectl proxy on localhost:8080
+func DefaultConfig() *Config {
+	return &Config{
+		APIServer: "http://localhost:8080",
+		Namespace: "kube-system",
+		Service:   "heapster-custom",
+	}
+}
+
+// ServiceURL returns the URL of the Heapster service, going through the API
+// server's service proxy unless an explicit URL is set
+func (c *Config) ServiceURL() string {
+	if c.URL != "" {
+		return strings.TrimSuffix(c.URL, "/")
+	}
+	service := c.Service
+	if c.Port != "" {
+		service += ":" + c.Port
+	}
+	return strings.TrimSuffix(c.APIServer, "/") +
+		"/api/v1/namespaces/" + c.Namespace + "/services/" + service + "/proxy"
+}
+
+// HTTPClient returns an http.Client carrying the TLS and authentication settings
+func (c *Config) HTTPClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: c.Insecure}
+
+	caData := c.CAData
+	if len(caData) == 0 && c.CAFile != "" {
+		data, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("heapster: reading CA file: %v", err)
+		}
+		caData = data
+	}
+	if len(caData) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("heapster: no certificates found in CA data")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	certData, keyData := c.CertData, c.KeyData
+	if len(certData) == 0 && c.CertFile != "" {
+		data, err := ioutil.ReadFile(c.CertFile)
+		if err != nil {
+			return nil, fmt.Errorf("heapster: reading client certificate: %v", err)
+		}
+		certData = data
+	}
+	if len(keyData) == 0 && c.KeyFile != "" {
+		data, err := ioutil.ReadFile(c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("heapster: reading client key: %v", err)
+		}
+		keyData = data
+	}
+	if len(certData) > 0 || len(keyData) > 0 {
+		cert, err := tls.X509KeyPair(certData, keyData)
+		if err != nil {
+			return nil, fmt.Errorf("heapster: loading client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	apiServer, err := url.Parse(c.APIServer)
+	if err != nil {
+		return nil, fmt.Errorf("heapster: invalid API server address %q: %v", c.APIServer, err)
+	}
+	return &http.Client{Transport: &authTransport{config: c, apiServer: apiServer, base: transport}}, nil
+}
+
+// Adds the bearer token or basic auth credentials to the requests to the
+// API server, and only to those: a Heapster URL given directly may be on
+// another host, which must not get the cluster credentials
+type authTransport struct {
+	config    *Config
+	apiServer *url.URL
+	base      http.RoundTripper
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.config.BearerToken == "" && t.config.Username == "" || !sameHost(req.URL, t.apiServer) {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	if t.config.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+t.config.BearerToken)
+	} else {
+		req.SetBasicAuth(t.config.Username, t.config.Password)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// Reports whether two URLs have the same scheme, host and port
+func sameHost(a, b *url.URL) bool {
+	return strings.EqualFold(a.Scheme, b.Scheme) && strings.EqualFold(a.Hostname(), b.Hostname()) && port(a) == port(b)
+}
+
+// Port of a URL, the scheme's default one if it has none
+func port(u *url.URL) string {
+	if p := u.Port(); p != "" {
+		return p
+	}
+	if strings.EqualFold(u.Scheme, "https") {
+		return "443"
+	}
+	return "80"
+}
+
+// NewClientFromConfig returns a Client for the Heapster service described by config
+func NewClientFromConfig(config *Config) (*Client, error) {
+	httpClient, err := config.HTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	client := NewClient(config.ServiceURL())
+	client.HTTPClient = httpClient
+	return client, nil
+}
+
+// NewAPIServerClient returns a Client for requests to the API server itself
+// rather than to Heapster, with the same credentials
+func NewAPIServerClient(config *Config) (*Client, error) {
+	httpClient, err := config.HTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	client := NewClient(config.APIServer)
+	client.HTTPClient = httpClient
+	return client, nil
+}
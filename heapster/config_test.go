@@ -0,0 +1,60 @@
+package heapster
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCredentialsOnlyToAPIServer(t *testing.T) {
+	var apiAuth, heapsterAuth string
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiAuth = r.Header.Get("Authorization")
+	}))
+	defer apiServer.Close()
+	heapsterServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		heapsterAuth = r.Header.Get("Authorization")
+	}))
+	defer heapsterServer.Close()
+
+	config := DefaultConfig()
+	config.APIServer = apiServer.URL
+	config.URL = heapsterServer.URL
+	config.BearerToken = "secret"
+	client, err := config.HTTPClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []string{apiServer.URL + "/api/v1/pods", config.ServiceURL() + "/api/v1/model/nodes/"} {
+		resp, err := client.Get(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if apiAuth != "Bearer secret" {
+		t.Errorf("API server got Authorization %q", apiAuth)
+	}
+	if heapsterAuth != "" {
+		t.Errorf("Heapster at another address got Authorization %q", heapsterAuth)
+	}
+}
+
+func TestSameHost(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		want bool
+	}{
+		{"https://10.0.0.1:6443/api", "https://10.0.0.1:6443", true},
+		{"https://k8s.example.com/api", "https://K8S.example.com:443", true},
+		{"http://localhost:8080/", "http://localhost:8081", false},
+		{"http://10.0.0.1:6443/", "https://10.0.0.1:6443", false},
+		{"https://heapster.example.com/", "https://k8s.example.com", false},
+	} {
+		a, _ := http.NewRequest("GET", c.a, nil)
+		b, _ := http.NewRequest("GET", c.b, nil)
+		if got := sameHost(a.URL, b.URL); got != c.want {
+			t.Errorf("sameHost(%s, %s) = %v, want %v", c.a, c.b, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Errors returned by the Client
+
+package heapster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// Error describes a failed request to Heapster
+type Error struct {
+	URL string
+
+	// HTTP status of the response, 0 if no response was received
+	StatusCode int
+
+	// Start of the response body for a bad status, to show proxy error pages
+	Body string
+
+	// Underlying cause when the request failed without a bad status
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.StatusCode != 0 && e.Err == nil {
+		msg := fmt.Sprintf("heapster: GET %s: %d %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+		if e.Body != "" {
+			msg += ": " + e.Body
+		}
+		return msg
+	}
+	return fmt.Sprintf("heapster: GET %s: %v", e.URL, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Temporary reports whether retrying the request may succeed: network
+// failures, timeouts, throttling and server side errors
+func (e *Error) Temporary() bool {
+	switch e.StatusCode {
+	case 0:
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+
+	// The request was cancelled by the caller rather than failing by itself
+	if errors.Is(e.Err, context.Canceled) {
+		return false
+	}
+	if errors.Is(e.Err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(e.Err, &netErr)
+}
+
+// IsNotFound reports whether err is a 404 response, which Heapster returns
+// for entities that no longer exist
+func IsNotFound(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.StatusCode == http.StatusNotFound
+}
+
+// IsForbidden reports whether err is a 401 or 403 response, which the API
+// server returns to credentials not allowed to make the request
+func IsForbidden(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && (e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden)
+}
@@ -0,0 +1,164 @@
+// Loading connection settings from a kubeconfig file
+
+package heapster
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Subset of the kubeconfig format needed to reach the API server
+type kubeconfig struct {
+	CurrentContext string `json:"current-context"`
+	Clusters       []struct {
+		Name    string `json:"name"`
+		Cluster struct {
+			Server                   string `json:"server"`
+			CertificateAuthority     string `json:"certificate-authority"`
+			CertificateAuthorityData string `json:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `json:"insecure-skip-tls-verify"`
+		} `json:"cluster"`
+	} `json:"clusters"`
+	Contexts []struct {
+		Name    string `json:"name"`
+		Context struct {
+			Cluster string `json:"cluster"`
+			User    string `json:"user"`
+		} `json:"context"`
+	} `json:"contexts"`
+	Users []struct {
+		Name string `json:"name"`
+		User struct {
+			Token                 string `json:"token"`
+			TokenFile             string `json:"tokenFile"`
+			ClientCertificate     string `json:"client-certificate"`
+			ClientCertificateData string `json:"client-certificate-data"`
+			ClientKey             string `json:"client-key"`
+			ClientKeyData         string `json:"client-key-data"`
+			Username              string `json:"username"`
+			Password              string `json:"password"`
+			// Credentials from a plugin, which are not supported
+			Exec         interface{} `json:"exec"`
+			AuthProvider interface{} `json:"auth-provider"`
+		} `json:"user"`
+	} `json:"users"`
+}
+
+// LoadKubeconfig reads the API server address and credentials of a context
+// from a kubeconfig file into a Config. An empty context selects the
+// file's current-context. Heapster service settings are left at their defaults.
+func LoadKubeconfig(path, context string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("kubeconfig: %v", err)
+	}
+
+	// kubeconfig files may be JSON or YAML; decode YAML through JSON so both
+	// end up in the same structs
+	if trimmed := strings.TrimSpace(string(data)); !strings.HasPrefix(trimmed, "{") {
+		tree, err := parseYAML(data)
+		if err != nil {
+			return nil, fmt.Errorf("kubeconfig %s: %v", path, err)
+		}
+		if data, err = json.Marshal(tree); err != nil {
+			return nil, fmt.Errorf("kubeconfig %s: %v", path, err)
+		}
+	}
+	kc := &kubeconfig{}
+	if err := json.Unmarshal(data, kc); err != nil {
+		return nil, fmt.Errorf("kubeconfig %s: %v", path, err)
+	}
+
+	if context == "" {
+		context = kc.CurrentContext
+	}
+	if context == "" {
+		return nil, fmt.Errorf("kubeconfig %s: no context given and no current-context set", path)
+	}
+
+	var clusterName, userName string
+	found := false
+	for _, c := range kc.Contexts {
+		if c.Name == context {
+			clusterName, userName = c.Context.Cluster, c.Context.User
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("kubeconfig %s: context %q not found", path, context)
+	}
+
+	// Relative file references are relative to the kubeconfig file
+	dir := filepath.Dir(path)
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+
+	config := DefaultConfig()
+	found = false
+	for _, c := range kc.Clusters {
+		if c.Name != clusterName {
+			continue
+		}
+		found = true
+		config.APIServer = c.Cluster.Server
+		config.Insecure = c.Cluster.InsecureSkipTLSVerify
+		config.CAFile = resolve(c.Cluster.CertificateAuthority)
+		if config.CAData, err = decodeData(c.Cluster.CertificateAuthorityData); err != nil {
+			return nil, fmt.Errorf("kubeconfig %s: cluster %q: certificate-authority-data: %v", path, clusterName, err)
+		}
+		break
+	}
+	if !found {
+		return nil, fmt.Errorf("kubeconfig %s: cluster %q not found", path, clusterName)
+	}
+
+	for _, u := range kc.Users {
+		if u.Name != userName {
+			continue
+		}
+		if u.User.Exec != nil || u.User.AuthProvider != nil {
+			plugin := "exec"
+			if u.User.Exec == nil {
+				plugin = "auth-provider"
+			}
+			return nil, fmt.Errorf("kubeconfig %s: user %q: unsupported user auth %s, only tokens, client certificates and basic auth are supported", path, userName, plugin)
+		}
+		config.BearerToken = u.User.Token
+		if config.BearerToken == "" && u.User.TokenFile != "" {
+			token, err := ioutil.ReadFile(resolve(u.User.TokenFile))
+			if err != nil {
+				return nil, fmt.Errorf("kubeconfig %s: user %q: %v", path, userName, err)
+			}
+			config.BearerToken = strings.TrimSpace(string(token))
+		}
+		config.Username, config.Password = u.User.Username, u.User.Password
+		config.CertFile = resolve(u.User.ClientCertificate)
+		config.KeyFile = resolve(u.User.ClientKey)
+		if config.CertData, err = decodeData(u.User.ClientCertificateData); err != nil {
+			return nil, fmt.Errorf("kubeconfig %s: user %q: client-certificate-data: %v", path, userName, err)
+		}
+		if config.KeyData, err = decodeData(u.User.ClientKeyData); err != nil {
+			return nil, fmt.Errorf("kubeconfig %s: user %q: client-key-data: %v", path, userName, err)
+		}
+		break
+	}
+
+	return config, nil
+}
+
+// Decodes a base64 *-data field, returning nil for an empty field
+func decodeData(s string) ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	return base64.StdEncoding.DecodeString(s)
+}
@@ -0,0 +1,115 @@
+package heapster
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- cluster:
+    certificate-authority: ca.crt
+    server: https://10.0.0.1:6443
+  name: prod
+- cluster:
+    insecure-skip-tls-verify: true
+    server: "https://ci.example.com"   # CI cluster
+  name: ci
+contexts:
+- context:
+    cluster: prod
+    user: admin
+  name: prod
+- name: ci
+  context:
+    cluster: ci
+    user: ci-bot
+- name: gke
+  context:
+    cluster: prod
+    user: gke-user
+current-context: prod
+users:
+- name: admin
+  user:
+    client-certificate-data: Y2VydA==
+    client-key-data: a2V5
+- name: ci-bot
+  user:
+    token: 'abc#123'
+- name: gke-user
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1beta1
+      command: gke-gcloud-auth-plugin
+      args: []
+`
+
+func writeKubeconfig(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config")
+	if err := ioutil.WriteFile(path, []byte(testKubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadKubeconfigCurrentContext(t *testing.T) {
+	path := writeKubeconfig(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	config, err := LoadKubeconfig(path, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.APIServer != "https://10.0.0.1:6443" {
+		t.Errorf("APIServer = %q", config.APIServer)
+	}
+	if config.CAFile != filepath.Join(filepath.Dir(path), "ca.crt") {
+		t.Errorf("CAFile = %q, want it relative to the kubeconfig", config.CAFile)
+	}
+	if string(config.CertData) != "cert" || string(config.KeyData) != "key" {
+		t.Errorf("CertData = %q, KeyData = %q", config.CertData, config.KeyData)
+	}
+	want := "https://10.0.0.1:6443/api/v1/namespaces/kube-system/services/heapster-custom/proxy"
+	if config.ServiceURL() != want {
+		t.Errorf("ServiceURL() = %q, want %q", config.ServiceURL(), want)
+	}
+}
+
+func TestLoadKubeconfigNamedContext(t *testing.T) {
+	path := writeKubeconfig(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	config, err := LoadKubeconfig(path, "ci")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.APIServer != "https://ci.example.com" || !config.Insecure {
+		t.Errorf("APIServer = %q, Insecure = %v", config.APIServer, config.Insecure)
+	}
+	if config.BearerToken != "abc#123" {
+		t.Errorf("BearerToken = %q", config.BearerToken)
+	}
+
+	if _, err := LoadKubeconfig(path, "missing"); err == nil {
+		t.Error("expected an error for an unknown context")
+	}
+}
+
+func TestLoadKubeconfigUnsupportedAuth(t *testing.T) {
+	path := writeKubeconfig(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	_, err := LoadKubeconfig(path, "gke")
+	if err == nil || !strings.Contains(err.Error(), "unsupported user auth exec") {
+		t.Errorf("got error %v, want one for the exec plugin", err)
+	}
+}
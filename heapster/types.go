@@ -0,0 +1,79 @@
+//Types for the Heapster model API (/api/v1/model/...)
+
+package heapster
+
+import (
+	"strings"
+	"time"
+)
+
+// MetricPoint is a single (timestamp, value) sample returned by the model API
+type MetricPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+}
+
+// MetricResult is the response for a single metric of a single entity
+type MetricResult struct {
+	Metrics         []MetricPoint `json:"metrics"`
+	LatestTimestamp time.Time     `json:"latestTimestamp"`
+}
+
+// MetricResultList is the response for a metric of several entities
+type MetricResultList struct {
+	Items []MetricResult `json:"items"`
+}
+
+// MetricAggregationBucket holds the aggregations of a metric over one bucket
+// Aggregations that were not requested are nil
+type MetricAggregationBucket struct {
+	Timestamp time.Time `json:"timestamp"`
+
+	Count   *float64 `json:"count,omitempty"`
+	Average *float64 `json:"average,omitempty"`
+	Maximum *float64 `json:"maximum,omitempty"`
+	Minimum *float64 `json:"minimum,omitempty"`
+	Median  *float64 `json:"median,omitempty"`
+
+	// Keyed by percentile, e.g. "95"
+	Percentiles map[string]float64 `json:"percentiles,omitempty"`
+}
+
+// MetricAggregationResult is the response for aggregations of a single metric
+// of a single entity
+type MetricAggregationResult struct {
+	Buckets    []MetricAggregationBucket `json:"buckets"`
+	BucketSize time.Duration             `json:"bucketSize"`
+}
+
+// Aggregations supported by the model API's metrics-aggregated endpoints
+var Aggregations = []string{"average", "maximum", "minimum", "median", "count", "50-perc", "95-perc", "99-perc"}
+
+// Points returns the value of one aggregation in every bucket as points,
+// leaving out buckets that lack it
+func (r *MetricAggregationResult) Points(aggregation string) []MetricPoint {
+	points := make([]MetricPoint, 0, len(r.Buckets))
+	for _, b := range r.Buckets {
+		var value *float64
+		switch aggregation {
+		case "count":
+			value = b.Count
+		case "average":
+			value = b.Average
+		case "maximum":
+			value = b.Maximum
+		case "minimum":
+			value = b.Minimum
+		case "median":
+			value = b.Median
+		default:
+			if p, ok := b.Percentiles[strings.TrimSuffix(aggregation, "-perc")]; ok {
+				value = &p
+			}
+		}
+		if value != nil {
+			points = append(points, MetricPoint{Timestamp: b.Timestamp, Value: *value})
+		}
+	}
+	return points
+}
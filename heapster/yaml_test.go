@@ -0,0 +1,61 @@
+package heapster
+
+import (
+	"reflect"
+	"testing"
+)
+
+type yamlMap = map[string]interface{}
+type yamlSeq = []interface{}
+
+func TestParseYAML(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		yaml string
+		want interface{}
+	}{
+		{"empty", "# nothing\n---\n", yamlMap{}},
+		{"nested mappings", "a:\n  b:\n    c: d\n  e: f\n", yamlMap{"a": yamlMap{"b": yamlMap{"c": "d"}, "e": "f"}}},
+		{"sequence under key", "names:\n- a\n- b\nnext: c\n", yamlMap{"names": yamlSeq{"a", "b"}, "next": "c"}},
+		{"indented sequence under key", "names:\n  - a\n  - b\n", yamlMap{"names": yamlSeq{"a", "b"}}},
+		{"key items", "users:\n- name: a\n  user:\n    token: t\n- name: b\n", yamlMap{"users": yamlSeq{
+			yamlMap{"name": "a", "user": yamlMap{"token": "t"}},
+			yamlMap{"name": "b"},
+		}}},
+		{"nested item", "items:\n-\n  a: b\n", yamlMap{"items": yamlSeq{yamlMap{"a": "b"}}}},
+		{"sequence item", "items:\n- - a\n  - b\n", yamlMap{"items": yamlSeq{yamlSeq{"a", "b"}}}},
+		{"quoted scalars", "a: \"x: #1\\n\"\nb: 'it''s'\n\"c d\": e\n", yamlMap{"a": "x: #1\n", "b": "it's", "c d": "e"}},
+		{"empty collections", "a: {}\nb: []\nc:\nd: null\n", yamlMap{"a": yamlMap{}, "b": yamlSeq{}, "c": nil, "d": nil}},
+		{"bools", "a: true\nb: false\nc: \"true\"\n", yamlMap{"a": true, "b": false, "c": "true"}},
+		{"comments", "# head\na: b # tail\nc: d#e\n  # indented\n", yamlMap{"a": "b", "c": "d#e"}},
+		{"top level sequence", "- a\n- b: c\n", yamlSeq{"a", yamlMap{"b": "c"}}},
+	} {
+		got, err := parseYAML([]byte(c.yaml))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %#v, want %#v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		yaml string
+	}{
+		{"tab indentation", "a:\n\tb: c\n"},
+		{"unexpected indentation", "a: b\n  c: d\n"},
+		{"not a mapping", "a: b\nc\n"},
+		{"unterminated quote", "a: 'b\n"},
+		{"flow mapping", "a: {b: c}\n"},
+		{"block scalar", "a: |\n  b\n"},
+		{"anchor", "a: &x b\n"},
+	} {
+		if got, err := parseYAML([]byte(c.yaml)); err == nil {
+			t.Errorf("%s: got %#v, want an error", c.name, got)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Client for the few Kubernetes API calls metrics-collect needs
+
+package kube
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"../heapster"
+)
+
+// Pod holds the fields of a Kubernetes pod used for selecting pods and
+// inspecting the Heapster deployment
+type Pod struct {
+	Metadata struct {
+		Name      string            `json:"name"`
+		Namespace string            `json:"namespace"`
+		Labels    map[string]string `json:"labels"`
+	} `json:"metadata"`
+	Spec struct {
+		NodeName   string      `json:"nodeName"`
+		Containers []Container `json:"containers"`
+	} `json:"spec"`
+}
+
+// Container of a pod spec
+type Container struct {
+	Name    string   `json:"name"`
+	Image   string   `json:"image"`
+	Command []string `json:"command"`
+	Args    []string `json:"args"`
+}
+
+type podList struct {
+	Items []Pod `json:"items"`
+}
+
+// Client makes requests to the Kubernetes API server
+type Client struct {
+	api *heapster.Client
+}
+
+// NewClient returns a Client sending its requests through api, a client whose
+// BaseURL is the API server (see heapster.NewAPIServerClient)
+func NewClient(api *heapster.Client) *Client {
+	return &Client{api: api}
+}
+
+// ListPods returns the pods of a namespace matching a label selector such as
+// "app=web,tier!=cache". An empty namespace lists pods of all namespaces and
+// an empty selector matches every pod.
+func (c *Client) ListPods(ctx context.Context, namespace, selector string) ([]Pod, error) {
+	path := "/api/v1/pods"
+	if namespace != "" {
+		path = "/api/v1/namespaces/" + url.PathEscape(namespace) + "/pods"
+	}
+	query := url.Values{}
+	if selector != "" {
+		query.Set("labelSelector", selector)
+	}
+
+	list := &podList{}
+	if err := c.api.Get(ctx, path, query, list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// Flag returns the value of a command line flag of the container, given in
+// its command or args as -name=value, --name=value or followed by the value
+func (c *Container) Flag(name string) (string, bool) {
+	args := append(append([]string(nil), c.Command...), c.Args...)
+	for i, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		arg = strings.TrimLeft(arg, "-")
+		if arg == name && i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+			return args[i+1], true
+		}
+		if strings.HasPrefix(arg, name+"=") {
+			return arg[len(name)+1:], true
+		}
+	}
+	return "", false
+}
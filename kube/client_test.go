@@ -0,0 +1,63 @@
+package kube
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"../heapster"
+)
+
+func TestListPods(t *testing.T) {
+	var gotPath, gotSelector string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotSelector = r.URL.Query().Get("labelSelector")
+		w.Write([]byte(`{"kind": "PodList", "items": [
+		  {"metadata": {"name": "web-1", "namespace": "default", "labels": {"app": "web"}},
+		   "spec": {"nodeName": "node-1", "containers": [{"name": "app", "args": ["--port=80"]}]}}
+		]}`))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	client := NewClient(heapster.NewClient(server.URL))
+	pods, err := client.ListPods(context.Background(), "default", "app=web,tier!=cache")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gotPath != "/api/v1/namespaces/default/pods" || gotSelector != "app=web,tier!=cache" {
+		t.Errorf("path = %q, labelSelector = %q", gotPath, gotSelector)
+	}
+	if len(pods) != 1 || pods[0].Metadata.Labels["app"] != "web" || pods[0].Spec.Containers[0].Args[0] != "--port=80" {
+		t.Errorf("pods = %+v", pods)
+	}
+
+	if _, err := client.ListPods(context.Background(), "", ""); err != nil || gotPath != "/api/v1/pods" {
+		t.Errorf("all namespaces: path = %q, err = %v", gotPath, err)
+	}
+}
+
+func TestContainerFlag(t *testing.T) {
+	c := &Container{
+		Command: []string{"/heapster", "--source=kubernetes:https://kubernetes.default"},
+		Args:    []string{"-metric_resolution=30s", "--sink", "influxdb:http://influxdb:8086", "--verbose"},
+	}
+	tests := []struct {
+		name, value string
+		ok          bool
+	}{
+		{"metric_resolution", "30s", true},
+		{"source", "kubernetes:https://kubernetes.default", true},
+		{"sink", "influxdb:http://influxdb:8086", true},
+		{"verbose", "", false},
+		{"metric", "", false},
+	}
+	for _, tt := range tests {
+		value, ok := c.Flag(tt.name)
+		if value != tt.value || ok != tt.ok {
+			t.Errorf("Flag(%q) = %q, %v, want %q, %v", tt.name, value, ok, tt.value, tt.ok)
+		}
+	}
+}
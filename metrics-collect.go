@@ -3,259 +3,578 @@
 
 package main
 
+import "flag"
 import "fmt"
-import "net/http"
-import "io/ioutil"
 import "time"
 import "strings"
 import "strconv"
 import "os"
+import "os/signal"
+import "context"
+import "sort"
 import "./gochartgen"
+import "./heapster"
+import "./series"
+import "./resample"
+import "./kube"
+import "./snapshot"
+import "./export"
+
+//Connection flags, see usage below
+var (
+	apiServer = flag.String("server", "http://localhost:8080", "")
+	kubeconfigPath = flag.String("kubeconfig", "", "")
+	kubeContext = flag.String("context", "", "")
+	token = flag.String("token", "", "")
+	clientCert = flag.String("client-certificate", "", "")
+	clientKey = flag.String("client-key", "", "")
+	caFile = flag.String("certificate-authority", "", "")
+	insecure = flag.Bool("insecure-skip-tls-verify", false, "")
+
+	heapsterNamespace = flag.String("heapster-namespace", "kube-system", "")
+	heapsterService = flag.String("heapster-service", "heapster-custom", "")
+	heapsterPort = flag.String("heapster-port", "", "")
+	heapsterURL = flag.String("heapster-url", "", "")
+)
+
+//Time window flags
+var (
+	since = flag.Duration("since", 0, "")
+	startFlag = flag.String("start", "", "")
+	endFlag = flag.String("end", "", "")
+)
+
+//Selection flags
+var (
+	namespaceList = flag.String("namespace", "default", "")
+	allNamespaces = flag.Bool("all-namespaces", false, "")
+	metricList = flag.String("metrics", "cpu/usage_rate", "")
+	deriveList = flag.String("derive", "", "")
+	selector = flag.String("selector", "", "")
+	includePods = flag.String("include-pods", "", "")
+	excludePods = flag.String("exclude-pods", "", "")
+	includeNodes = flag.String("include-nodes", "", "")
+	excludeNodes = flag.String("exclude-nodes", "", "")
+)
+
+//Fetching flags
+var (
+	workers = flag.Int("workers", 8, "")
+	perHost = flag.Int("per-host", 4, "")
+	timeout = flag.Duration("timeout", 30*time.Second, "")
+	retries = flag.Int("retries", 3, "")
+	batchPods = flag.Bool("batch-pods", true, "")
+	maxURLLength = flag.Int("max-url-length", 2000, "")
+)
+
+//Aggregation flags
+var (
+	aggregationList = flag.String("aggregations", "", "")
+	bucketSize = flag.Duration("bucket", 0, "")
+)
+
+//Watch flags
+var (
+	interval = flag.Duration("interval", 0, "")
+	duration = flag.Duration("duration", 0, "")
+)
+
+//Serve flags
+var (
+	listen = flag.String("listen", ":9102", "")
+)
+
+//Store flags
+var (
+	storeDir = flag.String("store", "", "")
+	retention = flag.Duration("retention", 0, "")
+)
+
+//Query flags
+var (
+	fromFlag = flag.String("from", "", "")
+	toFlag = flag.String("to", "", "")
+)
+
+//Output flags
+var (
+	fillFlag = flag.String("fill", "null", "")
+	stepFlag = flag.Duration("step", 0, "")
+	reduceFlag = flag.String("reduce", "avg", "")
+	raw = flag.Bool("raw", false, "")
+	snapshotFile = flag.String("snapshot", "", "")
+	csvFile = flag.String("csv", "", "")
+	csvLayout = flag.String("csv-layout", "wide", "")
+)
+
+//Chart flags
+var (
+	fromSnapshot = flag.String("from-snapshot", "", "")
+)
+
+var usage = `Usage: ./metrics-collect [flags] [<heapster-resolution> [<interval-minutes>]] <chart-type>
+       ./metrics-collect [flags] watch [flags] [<heapster-resolution> [<interval-minutes>]] <chart-type>
+       ./metrics-collect [flags] serve [flags] [<heapster-resolution> [<interval-minutes>]]
+       ./metrics-collect -store <dir> query [flags] <query> [<chart-type>]
+       ./metrics-collect chart -from-snapshot <file> [flags] <chart-type>
+
+The Heapster resolution, in seconds, is detected from the spacing of Heapster's timestamps or its
+--metric_resolution flag. Give it, or auto, only as a fallback; a value that disagrees is replaced with a warning.
+
+Time window flags:
+  -since                     Collect the last duration, e.g. 10m or 1h30m (default 15m).
+                             Same as giving interval-minutes.
+  -start                     Start of the window, RFC3339, e.g. 2016-05-23T10:00:00-07:00.
+  -end                       End of the window, RFC3339 (default now).
+                             -start with -since collects [start, start+since].
+
+Connection flags:
+  -server                    Kubernetes API server address (default http://localhost:8080, a kubectl proxy).
+  -kubeconfig                Kubeconfig file to take the API server address and credentials from.
+  -context                   Kubeconfig context to use instead of its current-context.
+  -token                     Bearer token for the API server.
+  -client-certificate        Client certificate file for the API server.
+  -client-key                Client key file for the API server.
+  -certificate-authority     CA file to verify the API server with.
+  -insecure-skip-tls-verify  Do not verify the API server's certificate.
+
+  -heapster-namespace        Namespace of the Heapster service (default kube-system).
+  -heapster-service          Name of the Heapster service (default heapster-custom).
+  -heapster-port             Port name or number of the Heapster service.
+  -heapster-url              Heapster URL to use directly instead of the API server proxy.
+
+Flags given on the command line override the kubeconfig.
+
+Selection flags:
+  -namespace                 Comma separated namespaces to collect pod metrics from (default default).
+  -all-namespaces            Collect pod metrics from every namespace known to Heapster.
+  -metrics                   Comma separated metrics to collect, as glob patterns matched against
+                             the metrics Heapster lists for each entity type, e.g. cpu/*,memory/working_set
+                             (default cpu/usage_rate).
+  -derive                    Semicolon separated derived metrics to compute from the collected ones, each
+                             name=expression or the name of a builtin, e.g.
+                             'cpu/usage_pct=cpu/usage_rate / cpu/request * 100;rx=rate(network/rx)'.
+                             Expressions use + - * / (with spaces around /), numbers, parentheses and
+                             rate(counter), the per second rate of a cumulative metric across counter resets.
+                             Builtins: cpu/request_utilization, cpu/limit_utilization,
+                             memory/request_utilization, memory/limit_utilization (percent of request/limit).
+                             The metrics an expression uses are collected as well.
+  -selector                  Label selector, e.g. app=web,tier!=cache. Only the pods the Kubernetes API
+                             returns for it are collected.
+  -include-pods              Regex pod names must match to be collected.
+  -exclude-pods              Regex of pod names not to collect.
+  -include-nodes             Regex node names must match to be collected.
+  -exclude-nodes             Regex of node names not to collect.
+                             The name filters also apply, alone, when -selector cannot be used
+                             because the Kubernetes API refuses access to pod labels.
+
+Fetching flags:
+  -workers                   Number of goroutines fetching from Heapster concurrently (default 8).
+  -per-host                  Maximum number of concurrent requests to the same host (default 4).
+  -timeout                   Time limit for each request, e.g. 10s (default 30s).
+  -retries                   Number of retries, with exponential backoff, after a temporary failure
+                             such as a timeout or a 503 from the proxy (default 3).
+  -batch-pods                Fetch the metrics of many pods at once through Heapster's pod-list
+                             endpoint, falling back to one request per pod (default true).
+  -max-url-length            Longest pod-list request URL, which sets how many pods are batched (default 2000).
+
+Aggregation flags:
+  -aggregations              Comma separated aggregations to collect instead of raw points, each as its own
+                             series: avg, max, min, median, count, p50, p95, p99.
+  -bucket                    Bucket size of the aggregations, e.g. 5m (default the Heapster resolution).
+
+Watch flags:
+  watch keeps polling Heapster, starting with the usual window, and only fetches the points newer than
+  those it already has of each series. It writes the charts of everything collected when interrupted.
+  -interval                  Time between polls (default the Heapster resolution).
+  -duration                  Stop watching after this long, e.g. 3h (default until interrupted).
+
+Serve flags:
+  serve keeps polling Heapster like watch and exposes the latest value of every collected and derived
+  series on /metrics for Prometheus to scrape, in its text format or OpenMetrics as the scrape asks.
+  Each metric is a gauge named heapster_ and the metric with other characters than letters, digits and
+  underscores replaced, e.g. cpu/usage_rate is heapster_cpu_usage_rate{namespace="web",pod="api-1",...},
+  in Heapster's raw units and with the time Heapster took it at. It uses -interval too, but not -csv
+  or -snapshot.
+  -listen                    Address to serve /metrics on (default :9102).
+
+Store flags:
+  -store                     Directory of a local store to also write the collected series to, e.g. ./metrics.
+                             Points already stored are skipped. watch and serve write the points of each poll
+                             to a block once a block window (2h) is over, and the rest when stopped. One process
+                             at a time can write to a store, while query can read it at any time.
+  -retention                 Delete stored blocks older than this, e.g. 168h (default keep everything).
+
+Query flags:
+  query prints the series of a local store matching a query, and charts them if a chart type is given,
+  without the cluster. A query selects series by entity type, labels and metric, e.g.
+  'pod{namespace="web",name=~"api-.*"}:cpu/usage_rate', where name stands for the entity type's label,
+  and may apply sum/avg/max [by (label,...)], rate(), avg_over_time(...[5m]) and max_over_time(...[5m]).
+  -from                      Start of the range to query, RFC3339 or a duration ago, e.g. 2h (default the first point).
+  -to                        End of the range to query, RFC3339 or a duration ago (default the last point).
+
+Output flags:
+  -fill                      How missing points are output: omit, null, previous, linear or zero (default null).
+                             Charts draw null points as gaps, and leave out timestamps no series has a value for
+                             with omit.
+  -step                      Resample the series to this step before output, e.g. 5m (default the collected
+                             resolution). A step finer than the resolution repeats each sample over its interval.
+  -reduce                    How the points in a resampled step are combined: avg, min, max, last or sum
+                             (default avg).
+  -raw                       Keep values in their raw units (bytes, millicores...) instead of scaling them to
+                             readable ones (MiB, cores, MB/s...).
+  -snapshot                  Also write everything collected to this JSON file: the window, resolution, grid,
+                             every series with its labels, unit and points, and Heapster's raw responses
+                             (the first 64 MiB of them).
+  -csv                       Also write the series to this CSV file, resampled, filled and scaled like the charts.
+                             Values are in full precision, missing ones are empty cells, and left out with -fill omit.
+  -csv-layout                wide: a timestamp column and a column per series, or long: a row per value with
+                             its timestamp, entity type, namespace, name, container, metric, value and unit
+                             (default wide).
+
+Chart flags:
+  chart draws the charts of a snapshot again, offline, with any chart type and output flags.
+  -from-snapshot             Snapshot file written with -snapshot.
+`
+
+//A container of a pod, or a free container of a node
+type containerRef struct {
+	parent string //pod or node name
+	name string
+}
+
+//Converts a metric result into a series aligned to the grid
+//A point is placed in the nearest slot if it is within half a step of it, and dropped otherwise
+func toSeries(labels series.Labels, result *heapster.MetricResult, grid series.Grid) *series.Series {
+	points := make([]series.Point, len(result.Metrics))
+	for i, point := range result.Metrics {
+		points[i] = series.Point{Time: point.Timestamp, Value: point.Value}
+	}
+	return series.New(labels, grid, points)
+}
 
-//Error check helper
-func check(e error) {
-    if e != nil {
-        panic(e)
-    }
+//Replaces the series of each metric and entity type of set by what f returns for them,
+//keeping the order of set, e.g. to scale each group the way its chart is
+func byMetricAndType(set series.Set, f func(series.Set) series.Set) series.Set {
+	result := make(series.Set, len(set))
+	var keys []string
+	indexes := make(map[string][]int)
+	for i, s := range set {
+		key := s.Metric() + " " + s.Labels.Type()
+		if _, ok := indexes[key]; !ok {
+			keys = append(keys, key)
+		}
+		indexes[key] = append(indexes[key], i)
+	}
+	for _, key := range keys {
+		group := make(series.Set, len(indexes[key]))
+		for j, i := range indexes[key] {
+			group[j] = set[i]
+		}
+		for j, s := range f(group) {
+			result[indexes[key][j]] = s
+		}
+	}
+	return result
 }
 
-// Sends an http GET request to the specified URL and returns the body content as a string
-// Prints an error and returns empty string on failure
-func httpGetReq(urlString string) (string) {
-
-	resp, err := http.Get(urlString);
-	check(err)
-	
-	defer resp.Body.Close()
-	
-	body, err := ioutil.ReadAll(resp.Body)
-	check(err)
-
-	//Convert body byte array to string
-	str := string(body[:])
-    return str
+//Scales the series of one metric to readable units for printing unless raw
+//Series of the same entity type share a scale, as in their charts, so that they can be compared
+func scaleForPrint(set series.Set, raw bool) series.Set {
+	if raw {
+		return set
+	}
+	return byMetricAndType(set, series.Set.Scaled)
 }
 
-//Removes whitespace and newline characters from string
-func removeWhitespace(str string)(string){
-	toks := strings.Split(str, "\n")
-	str = strings.Join(toks, "")
-	toks = strings.Fields(str);
-	str = strings.Join(toks, "")
+//Formats the values of a series for printing, followed by their unit
+//Values are printed in full if raw, and to 3 decimals otherwise
+func formatPoints(s *series.Series, raw bool) string {
+	values := make([]string, len(s.Points))
+	for i, p := range s.Points {
+		if raw {
+			values[i] = strconv.FormatFloat(p.Value, 'f', -1, 64)
+		} else {
+			values[i] = series.FormatValue(p.Value)
+		}
+	}
+	str := "[" + strings.Join(values, " ") + "]"
+	if s.Unit != series.None {
+		str += " " + string(s.Unit)
+	}
 	return str
 }
 
-//Parses the (timestamp, value) response string and extracts an array of values and timestamps from it
-func extractValues(str string, allTS []string) ([]int, []string, []int) {
+//Shorten a timestamp to a label in the given layout
+func shortenTimeStamp(ts time.Time, layout string)(string) {
+	return ts.UTC().Format(layout)
+}
 
-	//Remove all endline and spaces
-	str = removeWhitespace(str)
+//Chart labels for the timestamps of a grid
+//Minute:seconds for grids shorter than an hour, with the hour up to a day and
+//with the date beyond, so that labels do not repeat along the axis
+func timeLabels(grid series.Grid)([]string){
+	layout := "04:05"
+	if span := grid.End().Sub(grid.Start); span > 24*time.Hour {
+		layout = "01-02 15:04"
+	} else if span >= time.Hour {
+		layout = "15:04:05"
+	}
+	labels := make([]string, grid.Len)
+	for i, ts := range grid.Times() {
+		labels[i] = shortenTimeStamp(ts, layout)
+	}
+	return labels
+}
 
-	i := strings.Index(str, "[")
-	j := strings.Index(str, "]")
-	str = str[i+1:j]
+//Time window to collect, from -start/-end/-since or the interval-minutes argument
+//A zero start is computed from since, a zero end means now
+type window struct {
+	start time.Time
+	end time.Time
+	since time.Duration
+}
 
-	toks := strings.Split(str, "}")
-	toks = toks[:len(toks)-1] //remove the last empty token
+//Builds the time window from the flags and the optional interval-minutes argument
+func parseWindow(minutes int) (*window, error) {
+	w := &window{since: *since}
+	var err error
+	if *startFlag != "" {
+		if w.start, err = time.Parse(time.RFC3339, *startFlag); err != nil {
+			return nil, fmt.Errorf("invalid -start: %v", err)
+		}
+	}
+	if *endFlag != "" {
+		if w.end, err = time.Parse(time.RFC3339, *endFlag); err != nil {
+			return nil, fmt.Errorf("invalid -end: %v", err)
+		}
+	}
+	if minutes >= 0 {
+		if w.since != 0 || !w.start.IsZero() {
+			return nil, fmt.Errorf("interval-minutes cannot be combined with -since or -start")
+		}
+		w.since = time.Duration(minutes) * time.Minute
+	}
 
-	//Slices of values and timestamps
-	values := make([]int, len(toks))
-	timestamps := make([]string, len(toks))
+	switch {
+	case !w.start.IsZero() && w.since != 0:
+		if !w.end.IsZero() {
+			return nil, fmt.Errorf("-start, -end and -since cannot all be given")
+		}
+		w.end = w.start.Add(w.since)
+	case w.start.IsZero() && w.since == 0:
+		//Heapster only has 15 minutes of data
+		w.since = 15 * time.Minute
+	}
+	if !w.start.IsZero() && !w.end.IsZero() && !w.start.Before(w.end) {
+		return nil, fmt.Errorf("-start %s is not before -end %s", w.start.Format(time.RFC3339), w.end.Format(time.RFC3339))
+	}
+	return w, nil
+}
 
-	for i, tok := range toks {
-		//Get value
-		tmp := strings.Split(tok, "\"value\":")
-		v := tmp[1];
-		//Convert value to int
-		num, err := strconv.Atoi(v)
-		check(err)
-		values[i] = num
+//Return the interval [start, end] to request and the cluster cpu/usage_rate in it,
+//whose latest timestamp and spacing the grid and resolution are derived from
+func timeInterval(ctx context.Context, w *window, client *heapster.Client)(time.Time, time.Time, *heapster.MetricResult) {
 
-		//Get time stamp
-		tmp = strings.Split(tmp[0], "{\"timestamp\":")
-		t := tmp[1];
-		t = t[1:len(t)-2]
-		timestamps[i] = t
+	//Construct start and end times
+	endTime := w.end
+	if endTime.IsZero() {
+		endTime = time.Now()
+	}
+	startTime := w.start
+	if startTime.IsZero() {
+		startTime = endTime.Add(-w.since)
 	}
 
-	//Construct a slice of values for all timestamps in the range of allTS
-	//Useful for plotting purposes to see missing (value,timestamp) pairs
-	allValues := make([]int, len(allTS))
-	for i, _ := range allValues {
-		allValues[i] = -100; //default value for missing data point
+	//Get actual latest timestamp from cluster
+	result, err := client.ClusterMetric(ctx, "cpu/usage_rate", startTime, endTime)
+	if err != nil {
+		fmt.Printf("Error: cannot get the latest timestamp from Heapster: %v\n", err)
+		os.Exit(1)
+	}
+	if result.LatestTimestamp.IsZero() && len(result.Metrics) > 0 {
+		result.LatestTimestamp = result.Metrics[len(result.Metrics)-1].Timestamp
+	}
+	if result.LatestTimestamp.IsZero() {
+		fmt.Printf("Error: No cpu/usage_rate data returned for [%s, %s]\n", startTime.Format(time.RFC3339), endTime.Format(time.RFC3339))
+		os.Exit(1)
 	}
 
-	tsShort := shortenTSArray(timestamps)
+	return startTime, endTime, result
+}
 
-	var l int = 0
-	//For every value
-	for k, val := range values {
-		//Find the index of its matching timestamp, and place the value there.
-		for allTS[l] != tsShort[k] {
-			l++
-		}
-		allValues[l] = val
+//Return the grid of timestamps expected in [start, end] at the given resolution
+//The expected timestamps are counted back from Heapster's latestTimestamp,
+//so that they fall on the same grid as the points Heapster returns
+func timeGrid(startTime, endTime, latest time.Time, res time.Duration) series.Grid {
+
+	//Last expected timestamp: the latest one Heapster has, stepped back
+	//onto the window when the window ends before it
+	gridEnd := latest
+	if gridEnd.After(endTime) {
+		steps := (gridEnd.Sub(endTime) + res - 1) / res
+		gridEnd = gridEnd.Add(-steps * res)
 	}
 
-	return values, timestamps, allValues
+	return series.NewGrid(startTime, gridEnd, res)
 }
 
-//Extracts an array of names from the (node/pod name) response string
-func extractNames(str string) ([]string) {
-
-	//Remove all endline and spaces
-	tok := strings.Split(str, "\n")
-	str = strings.Join(tok, "")
-	tok = strings.Fields(str);
-	str = strings.Join(tok, "")
-
-	str = str[1:len(str)-1]
-	tok = strings.Split(str, ",")
-	//fmt.Printf("\nAfter split:\n %s", str)
-	//if()
-
-	//Make a slice of names
-	if(len(tok[0]) == 0) { //If no names present, return empty slice
-		names := make([]string, 0)
-		fmt.Printf("")
-		return names
-	} else {
-		names := make([]string, len(tok))
-		for i, t := range tok {
-			t = t[1:len(t)-1]
-			names[i] = t
+//Prints the results of fetched jobs [metric type][entity] and returns them as series
+//A failed job is recorded in failures and stored as a series of missing values
+func collectResults(jobs [][]*fetchJob, metricTypes []string, entities []entity, grid series.Grid, out *output, failures *failureLog) series.Set {
+	set := make(series.Set, 0)
+	for i, metricType := range metricTypes {
+		out.printf("\nMetric Type: %s\n", metricType)
+		metricSet := make(series.Set, len(entities))
+		for k, e := range entities {
+			result := jobs[i][k].result
+			if jobs[i][k].err != nil {
+				failures.add(e.labels.Name() + " " + metricType, jobs[i][k].err)
+				result = &heapster.MetricResult{}
+			}
+			metricSet[k] = toSeries(e.labels.Copy(series.LabelMetric, metricType), result, grid)
+		}
+		for k, s := range scaleForPrint(metricSet, out.raw) {
+			if jobs[i][k].err != nil {
+				out.printf("%s: failed\n", entities[k].labels.Name())
+			} else {
+				out.printf("%s: %s\n", entities[k].labels.Name(), formatPoints(s, out.raw))
+			}
 		}
-		return names
+		set = append(set, metricSet...)
 	}
-	
+	return set
 }
 
-//Shorten an RFC399 timestamp to just minute:seconds string
-func shortenTimeStamp(ts string)(string) {
-	toks := strings.Split(ts, "T")
-	str := toks[1]
-	str = str[3:8]
-	return str
+//How collected series are output
+type output struct {
+	grid series.Grid //grid series are resampled to
+	reducer resample.Reducer
+	fill series.Fill
+	raw bool //values are not scaled to readable units
+	layout export.Layout //of the -csv file
+	quiet bool //collected values are not printed
 }
 
-//Shorten an entire array of timestamps to minute:seconds format
-func shortenTSArray(sliceTS []string)([]string){
-	for i, ts := range sliceTS {
-		sliceTS[i] = shortenTimeStamp(ts)
+//Prints unless quiet
+func (out *output) printf(format string, a ...interface{}) {
+	if !out.quiet {
+		fmt.Printf(format, a...)
 	}
-	return sliceTS
 }
 
-//Return RFC3999 interval [start, end] of m minutes
-//Where end = current time
-//resoultion in seconds
-func timeInterval(m int, resolution int, urlPrefix string)(string, string, []string) {
-
-	//Construct start and end times
-	minutes := -m
-	endTime := time.Now()
-	intervalDuration := time.Duration(minutes)*time.Minute
-	startTime := endTime.Add(intervalDuration)
-
-	//Format to RFC3339
-	startTimeStr := startTime.Format(time.RFC3339)
-	endTimeStr := endTime.Format(time.RFC3339)
-
-	//Get actual latest timestamp from cluster
-	metricCmd := "/api/v1/model/metrics/cpu/usage_rate" + "?start=" + startTimeStr + "&end=" + endTimeStr
-	str := httpGetReq(urlPrefix + metricCmd)
-
-	//Hardcoded way to parse and extract the latest timestamp from response string 
-	//TODO:Fix later
-	str = removeWhitespace(str)
-	str = str[len(str)-8:len(str)-3]
-
-	//Update the end time value for difference in min:seconds from latest timestamp
-	newEndStr := endTimeStr[:len(endTimeStr)-11] + str + endTimeStr[len(endTimeStr)-6:]
-	//Parse it to update end time
-	newEndTime, err := time.Parse(time.RFC3339, newEndStr)
-	check(err)
-
-	//Construct slice of expected timestamps
-	res := time.Duration(resolution) * time.Second
-	steps := int(-intervalDuration/res)
-
-	//timestampsMap := make(map[string]bool)
-	timestampsSlice := make([]string, steps+1)
-	ts := newEndTime.Add(intervalDuration)
-	for i := 0; i <= steps; i++ {
-		t := ts.Format(time.RFC3339)
-		//timestampsMap[t] = true
-		timestampsSlice[i] = t
-		ts = ts.Add(res)
-	}
-
-	return startTimeStr, endTimeStr, timestampsSlice
+//Resamples series to the output grid, fills their gaps and scales them
+func (out *output) prepare(set series.Set) series.Set {
+	data := resample.All(set, out.grid, out.reducer)
+	for _, s := range data {
+		s.Values = out.fill.Apply(s.Values)
+	}
+	if !out.raw {
+		data = data.Scaled()
+	}
+	return data
 }
 
-//Prepare a 3d slice for appending integer data
-func make3DsliceInt(innerSliceLen int) [][][]int {
+//Chart files of each entity type: the file name prefix and the labels,
+//besides the metric, that split its series into files
+var chartFiles = []struct {
+	entityType string
+	prefix string
+	by []string
+}{
+	{series.TypeCluster, "Cluster-", nil},
+	{series.TypeNode, "Node-", nil},
+	{series.TypeNamespace, "Namespace-", nil},
+	{series.TypePod, "Pod-", []string{series.LabelNamespace}},
+	{series.TypeContainer, "Container-", []string{series.LabelNamespace}},
+	{series.TypeFreeContainer, "FreeContainer-", nil},
+}
 
-	arr3 := make([][][]int, innerSliceLen)
-	for j := 0; j < innerSliceLen; j++ {
-		arr2 := make([][]int, 0)//0 so we can append rows into it
-		arr3[j] = arr2
+//Makes a chart file for each entity type and metric, with a line per entity
+//Pod and container charts are split by namespace, e.g. Pod-<namespace>-<metric>
+func generateCharts(set series.Set, chartType string, out *output) {
+
+	//Timestamps are only shortened for the chart labels
+	labels := timeLabels(out.grid)
+
+	for _, file := range chartFiles {
+		for _, group := range set.OfType(file.entityType).GroupBy(append(file.by, series.LabelMetric)...) {
+			fnamePrefix := file.prefix
+			for _, name := range file.by {
+				fnamePrefix += group.Labels[name] + "-"
+			}
+			metricType := group.Labels[series.LabelMetric]
+
+			data := out.prepare(group.Set)
+			xAxisLabels := labels
+			if out.fill == series.FillOmit {
+				xAxisLabels, data = omitMissing(labels, data)
+			}
+			gochartgen.CreateSeriesChartFile(fnamePrefix + metricType, chartType, xAxisLabels, data, metricType)
+		}
 	}
-	return arr3
 }
 
-//Prepare a 3d slice for appending string data
-func make3DsliceString(innerSliceLen int) [][][]string {
-
-	arr3 := make([][][]string, innerSliceLen)
-	for j := 0; j < innerSliceLen; j++ {
-		arr2 := make([][]string, 0)//0 so we can append rows into it
-		arr3[j] = arr2
+//Leaves out the grid slots where all series are missing
+func omitMissing(labels []string, data series.Set) ([]string, series.Set) {
+	keep := make([]int, 0, len(labels))
+	for j := range labels {
+		for _, s := range data {
+			if !series.IsMissing(s.Values[j]) {
+				keep = append(keep, j)
+				break
+			}
+		}
 	}
-	return arr3
+	kept := make([]string, len(keep))
+	for n, j := range keep {
+		kept[n] = labels[j]
+	}
+	for _, s := range data {
+		values := make([]float64, len(keep))
+		for n, j := range keep {
+			values[n] = s.Values[j]
+		}
+		s.Values = values
+	}
+	return kept, data
 }
 
-//Prepares data for calling createTimeSeriesChartFile()
-//Used for nodes and pods which are 3d matrices. Not for cluster which is a 2d matrix
-func generateCharts(fnamePrefix string, chartType string, metricTypes []string, names []string, metrics [][][]int, shortTS []string) {
+//Chart types gochart draws
+var chartTypes = map[string]bool { "spline": true, "line": true, "bar": true, "column": true, "area": true }
 
-	//Make a line chart file for each type of cluster metric
-	for i, metricType := range metricTypes {
-		xAxisData := shortTS
-		//Values
-		yAxisData := make([][]int, 0)
-		yAxisLineNames := make([]string, 0)
-
-		yAxisText := metricType
-		for k, name := range names {
-			yAxisData = append(yAxisData, metrics[i][k])
-			yAxisLineNames = append(yAxisLineNames, name)
-		}
-		
-		//Create chart file
-		gochartgen.CreateTimeSeriesChartFileTS(fnamePrefix + metricType, chartType, xAxisData, yAxisData, yAxisLineNames, yAxisText)
+//Parses a whole number argument, exiting with an error if it is not one
+func atoiArg(name, value string) int {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		fmt.Printf("Error: invalid %s %q, want a whole number\n", name, value)
+		os.Exit(1)
 	}
+	return n
 }
 
 //Check for correct arguments of minutes and chartype
+//heapster-resolution is optional (or auto), 0 when not given, and interval-minutes -1
 func checkArgs(args []string) (int, int, string) {
 
-	if len(args) != 3 {
-		fmt.Printf("Usage: ./metrics_collect <heapster-resolution> <interval-minutes> <chart-type>\n")
+	if len(args) < 1 || len(args) > 3 {
+		fmt.Print(usage)
 		os.Exit(1)
 	}
 
-	chartTypes := map[string]bool { "spline": true, "line": true, "bar": true, "column": true, "area": true }
-
 	fmt.Printf("Map: %v\n\n", chartTypes)
 
-	resolution, err := strconv.Atoi(args[0])
-	check(err)
+	resolution := 0
+	if len(args) >= 2 && args[0] != "auto" {
+		resolution = atoiArg("heapster-resolution", args[0])
+	}
 
-	minutes, err := strconv.Atoi(args[1])
-	check(err)
+	minutes := -1
+	if len(args) == 3 {
+		minutes = atoiArg("interval-minutes", args[1])
+	}
 
-	chartType := args[2]
+	chartType := args[len(args)-1]
 
 	if !chartTypes[chartType] {
 		fmt.Printf("Valid Chart types: spline/line/bar/column/area\n")
@@ -267,131 +586,279 @@ func checkArgs(args []string) (int, int, string) {
 
 
 
+//Builds the Heapster connection settings from the kubeconfig and the flags
+func heapsterConfig() *heapster.Config {
+	config := heapster.DefaultConfig()
+	if *kubeconfigPath != "" {
+		var err error
+		config, err = heapster.LoadKubeconfig(*kubeconfigPath, *kubeContext)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	//Flags given explicitly override the kubeconfig
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "server":
+			config.APIServer = *apiServer
+		case "token":
+			config.BearerToken = *token
+		case "client-certificate":
+			config.CertFile, config.CertData = *clientCert, nil
+		case "client-key":
+			config.KeyFile, config.KeyData = *clientKey, nil
+		case "certificate-authority":
+			config.CAFile, config.CAData = *caFile, nil
+		case "insecure-skip-tls-verify":
+			config.Insecure = *insecure
+		}
+	})
+
+	config.Namespace = *heapsterNamespace
+	config.Service = *heapsterService
+	config.Port = *heapsterPort
+	config.URL = *heapsterURL
+	return config
+}
+
+//Returns the namespaces to collect namespace and pod metrics from
+func selectNamespaces(ctx context.Context, client *heapster.Client) ([]string, error) {
+	if *allNamespaces {
+		namespaces, err := client.ListNamespaces(ctx)
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(namespaces)
+		return namespaces, nil
+	}
+
+	namespaces := make([]string, 0)
+	for _, ns := range strings.Split(*namespaceList, ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	return namespaces, nil
+}
+
 func main() {
 
-	args := os.Args[1:]
-	resolution, minutes, chartType := checkArgs(args)
+	flag.Usage = func() {
+		fmt.Print(usage)
+	}
+	flag.Parse()
+	args := flag.Args()
+	if len(args) > 0 && args[0] == "query" {
+		runQuery(args[1:])
+		return
+	}
+	if len(args) > 0 && args[0] == "chart" {
+		runChart(args[1:])
+		return
+	}
+	watching := len(args) > 0 && args[0] == "watch"
+	serving := len(args) > 0 && args[0] == "serve"
+	if watching || serving {
+		//Flags may follow the subcommand too
+		flag.CommandLine.Parse(args[1:])
+		args = flag.Args()
+	}
+	var resolution, minutes int
+	var chartType string
+	if serving {
+		resolution, minutes = checkServeArgs(args)
+	} else {
+		resolution, minutes, chartType = checkArgs(args)
+	}
+	win, err := parseWindow(minutes)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if (watching || serving) && !win.end.IsZero() {
+		fmt.Printf("Error: -end cannot be used with watch or serve\n")
+		os.Exit(1)
+	}
+	//serve only exposes the latest values, it writes no files
+	if serving && (*csvFile != "" || *snapshotFile != "") {
+		fmt.Printf("Error: -csv and -snapshot cannot be used with serve\n")
+		os.Exit(1)
+	}
 
+	//Cancel outstanding requests on interrupt
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
 	//Heapster service URL
-	//Needs kubectl proxy running
-	heapsterServiceURLPrefix := "http://localhost:8080/api/v1/proxy/namespaces/kube-system/services/heapster-custom"
-
-	//Set time interval of measurment for last m minutes [now-m, now]
-	//Heapster only has 15 minutes of data
-	start, end, sliceTS := timeInterval(minutes, resolution, heapsterServiceURLPrefix)
-
-	sliceTS = shortenTSArray(sliceTS)
-
-	//Cluster metrics
-	clusterMetricTypes := [] string{"cpu/usage_rate"}//, "memory/usage"}
-	//Node metrics
-	nodeMetricTypes := [] string{}//"cpu/node_utilization", "memory/node_utilization", "memory/working_set", "network/tx_rate"}
-	//Pod metrics
-	podMetricTypes := [] string{"cpu/usage_rate"}//, "memory/usage", "memory/working_set", "network/tx_rate"}
-
-
-	//Get list of node names
-	responseStr := httpGetReq(heapsterServiceURLPrefix + "/api/v1/model/nodes/")
-	nodeNames := extractNames(responseStr)
-	if len(nodeNames) == 0 {
-		fmt.Printf("Error: No nodeNames returned\n")
-	}
-	//Get list of pod names
-	responseStr = httpGetReq(heapsterServiceURLPrefix + "/api/v1/model/namespaces/default/pods/")
-	podNames := extractNames(responseStr)
-	if len(podNames) == 0 {
-		fmt.Printf("Error: No podNames returned\n")
-	}
-
-	//Matrix variables to store final metrics ints
-	clusterMetrics := make([][]int, 0) // 2D[metric type][values]
-	nodeMetrics := make3DsliceInt(len(nodeMetricTypes)) //3D[metric type][node name][values]
-	podMetrics := make3DsliceInt(len(podMetricTypes)) //3D[metric type][pod name][values]
-
-	//Timestamp(RFC3999) string matrices for the above metrics
-	clusterTS := make([][]string, 0) // 2D[metric type][ts]
-	nodeTS := make3DsliceString(len(nodeMetricTypes)) //3D[metric type][node name][ts]
-	podTS := make3DsliceString(len(podMetricTypes)) //3D[metric type][pod name][ts]
-
-	//Extended values for plotting missing values as well
-	clusterMetricsE := make([][]int, 0) // 2D[metric type][values]
-	nodeMetricsE := make3DsliceInt(len(nodeMetricTypes)) //3D[metric type][node name][values]
-	podMetricsE := make3DsliceInt(len(podMetricTypes)) //3D[metric type][pod name][values]
-
-	//Get all metrics for the cluster
-	fmt.Printf("\nCLUSTER METRICS\n\n")
-	for _, metricType := range clusterMetricTypes {
-		metricCmd := "/api/v1/model/metrics/" + metricType + "?start=" + start + "&end=" + end
-		responseStr = httpGetReq(heapsterServiceURLPrefix + metricCmd)
-		values, timestamps, eValues := extractValues(responseStr, sliceTS)
-		fmt.Printf("%s: %v\n", metricType, values)
-		clusterMetrics = append(clusterMetrics, values)
-		clusterTS = append(clusterTS, timestamps)
-		clusterMetricsE = append(clusterMetricsE, eValues)
-	}
-
-	//Get all metrics for each node
-	fmt.Printf("\n\nNODE METRICS\n")
-	for i, metricType := range nodeMetricTypes {
-		fmt.Printf("\nMetric Type: %s\n", metricType)
-		for _, nodeName := range nodeNames {
-			metricCmd := "/api/v1/model/nodes/" + nodeName + "/metrics/" + metricType + "?start=" + start + "&end=" + end
-			responseStr = httpGetReq(heapsterServiceURLPrefix + metricCmd)
-			values, timestamps, eValues := extractValues(responseStr, sliceTS)
-			fmt.Printf("%s: %v\n", nodeName, values)
-			nodeMetrics[i] = append(nodeMetrics[i], values)
-			nodeTS[i] = append(nodeTS[i], timestamps)
-			nodeMetricsE[i] = append(nodeMetricsE[i], eValues)
-		}
+	//Through the API server's service proxy unless -heapster-url is given
+	config := heapsterConfig()
+	client, err := heapster.NewClientFromConfig(config)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	client.MaxPerHost = *perHost
+	client.Timeout = *timeout
+	client.Retries = *retries
+
+	//Heapster's responses are kept for the snapshot
+	recorder := &snapshot.Recorder{MaxBytes: snapshotResponseBytes}
+	if *snapshotFile != "" {
+		client.OnResponse = recorder.Record
+	}
+
+	//Kubernetes API client for label selectors, with the same credentials and limits
+	apiClient, err := heapster.NewAPIServerClient(config)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	apiClient.MaxPerHost = *perHost
+	apiClient.Timeout = *timeout
+	apiClient.Retries = *retries
+	kubeClient := kube.NewClient(apiClient)
+
+	podFilter, err := newNameFilter(*includePods, *excludePods)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	nodeFilter, err := newNameFilter(*includeNodes, *excludeNodes)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	//Aggregations are collected in buckets instead of at the Heapster resolution
+	aggs, err := parseAggregations(*aggregationList)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	derived, derivedPatterns, err := parseDerived(*deriveList)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	fill, err := series.ParseFill(*fillFlag)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
 	}
+	reducer, err := resample.ParseReducer(*reduceFlag)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	layout, err := export.ParseLayout(*csvLayout)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	store, err := openStore(false)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer closeStore(store)
 
+	//Set time interval of measurment, by default the last 15 minutes [now-15m, now]
+	start, end, probe := timeInterval(ctx, win, client)
 
-	//Get all metrics for each pod
-	fmt.Printf("\n\nPOD METRICS\n")
-	for i, metricType := range podMetricTypes {
-		fmt.Printf("\nMetric Type: %s\n", metricType)
-		for _, podName := range podNames {
-			metricCmd := "/api/v1/model/namespaces/default/pods/" + podName + "/metrics/" + metricType + "?start=" + start + "&end=" + end
-			responseStr = httpGetReq(heapsterServiceURLPrefix + metricCmd)
-			values, timestamps, eValues := extractValues(responseStr, sliceTS)
-			fmt.Printf("%s: %v\n", podName, values)
-			podMetrics[i] = append(podMetrics[i], values)
-			podTS[i] = append(podTS[i], timestamps)
-			podMetricsE[i] = append(podMetricsE[i], eValues)
-		}
+	//The resolution is inferred from Heapster, the argument is only a fallback
+	res, err := detectResolution(ctx, kubeClient, probe, time.Duration(resolution) * time.Second)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
 	}
 
+	bucket := *bucketSize
+	if bucket == 0 {
+		bucket = res
+	}
+	step := res
+	if len(aggs) > 0 {
+		step = bucket
+	}
+	grid := timeGrid(start, end, probe.LatestTimestamp, step)
 
-	///////// Generate chart files from the matrices ////////////
+	//Series are output on the collected grid unless resampled with -step
+	out := &output{grid: grid, reducer: reducer, fill: fill, raw: *raw, layout: layout}
+	if *stepFlag > 0 {
+		out.grid = resample.Grid(grid, *stepFlag)
+	}
 
-	//CLUSTER CHARTS
-	//Filename prefix
-	fnamePrefix := "Cluster-"
-	//Make a line chart file for each type of cluster metric
-	for i, metricType := range clusterMetricTypes {
-		xAxisData := sliceTS
-		//Values
-		yAxisData := make([][]int, 0)
-		yAxisLineNames := make([]string, 0)
+	//Collect every selected entity over the window
+	c := &collector{
+		client: client,
+		kubeClient: kubeClient,
+		podFilter: podFilter,
+		nodeFilter: nodeFilter,
+		patterns: append(parseMetricPatterns(*metricList), derivedPatterns...),
+		aggs: aggs,
+		bucket: bucket,
+	}
+	pollInterval := *interval
+	if pollInterval <= 0 {
+		pollInterval = step
+	}
+	if serving {
+		//Values are only served, never printed or charted
+		out.quiet = true
+		serve(ctx, c, store, derived, start, probe.LatestTimestamp, step, pollInterval, *listen, out)
+		return
+	}
+	failures := &failureLog{}
+	var collected series.Set
+	if watching {
+		//Values are only printed once done, the grid spans everything collected
+		out.quiet = true
+		collected, grid = watch(ctx, c, store, start, step, pollInterval, *duration, out)
+		out.grid = grid
+		if *stepFlag > 0 {
+			out.grid = resample.Grid(grid, *stepFlag)
+		}
+		fmt.Printf("Collected %d series over %d timestamps\n", len(collected), grid.Len)
+	} else {
+		collected, err = c.collect(ctx, start, end, grid, out, failures)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		if n, err := save(store, collected); err != nil {
+			fmt.Printf("Warning: cannot store the collected points: %v\n", err)
+		} else if store != nil {
+			fmt.Printf("\nStored %d new points in %s\n", n, *storeDir)
+		}
+	}
 
-		yAxisText := metricType
-		yAxisData = append(yAxisData, clusterMetricsE[i])
-		yAxisLineNames = append(yAxisLineNames, "k8s-cluster")
+	//Derived metrics of every entity that has the metrics they use
+	if len(derived) > 0 {
+		out.printf("\n\nDERIVED METRICS\n")
+	}
+	for _, d := range derived {
+		out.printf("\nMetric Type: %s\n", d.Name)
+		set := d.Apply(collected)
+		for _, s := range scaleForPrint(set, out.raw) {
+			out.printf("%s: %s\n", s.Name(), formatPoints(s, out.raw))
+		}
+		collected = append(collected, set...)
+	}
 
-		//Create chart file
-		gochartgen.CreateTimeSeriesChartFileTS(fnamePrefix + metricType, chartType, xAxisData, yAxisData, yAxisLineNames, yAxisText)
+	if *snapshotFile != "" {
+		if watching {
+			end = time.Now()
+		}
+		writeSnapshot(collected, grid, start, end, res, recorder)
 	}
 
-	//NODE CHARTS
-	//Filename prefix
-	fnamePrefix = "Node-"
-	generateCharts(fnamePrefix, chartType, nodeMetricTypes, nodeNames, nodeMetricsE, sliceTS)
-	
+	//Chart files for every entity type and metric
+	generateCharts(collected, chartType, out)
+	if *csvFile != "" {
+		writeCSV(collected, out)
+	}
 
-	//POD CHARTs
-	//Filename prefix
-	fnamePrefix = "Pod-"
-	generateCharts(fnamePrefix, chartType, podMetricTypes, podNames, podMetricsE, sliceTS)
-	
+	failures.print()
 }
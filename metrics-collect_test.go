@@ -0,0 +1,127 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"./series"
+)
+
+func TestScaleForPrint(t *testing.T) {
+	grid := series.Grid{Start: time.Unix(0, 0), Step: time.Minute, Len: 1}
+	memory := func(labels series.Labels, v float64) *series.Series {
+		labels = labels.Copy(series.LabelMetric, "memory/usage")
+		return series.New(labels, grid, []series.Point{{Time: grid.Start, Value: v}})
+	}
+	set := series.Set{
+		memory(series.Labels{series.LabelNamespace: "web", series.LabelPod: "api-1"}, 3<<30),
+		memory(series.Labels{series.LabelNode: "node-1"}, 512<<20),
+		memory(series.Labels{series.LabelNamespace: "web", series.LabelPod: "api-2"}, 2<<20),
+	}
+	// Pods share the scale of the largest pod, the node has its own
+	want := []struct {
+		unit  series.Unit
+		value float64
+	}{{series.GiB, 3}, {series.MiB, 512}, {series.GiB, 2.0 / 1024}}
+	for i, s := range scaleForPrint(set, false) {
+		if s.Unit != want[i].unit || s.Points[0].Value != want[i].value {
+			t.Errorf("%s: got %v %s, want %v %s", s.Name(), s.Points[0].Value, s.Unit, want[i].value, want[i].unit)
+		}
+	}
+	if raw := scaleForPrint(set, true); raw[0].Unit != series.Bytes || raw[0].Points[0].Value != 3<<30 {
+		t.Errorf("raw: got %v %s", raw[0].Points[0].Value, raw[0].Unit)
+	}
+}
+
+func TestParseWindow(t *testing.T) {
+	defer func(start, end string, d time.Duration) {
+		*startFlag, *endFlag, *since = start, end, d
+	}(*startFlag, *endFlag, *since)
+
+	at := func(s string) time.Time {
+		ts, _ := time.Parse(time.RFC3339, s)
+		return ts
+	}
+	for _, c := range []struct {
+		start, end string
+		since      time.Duration
+		minutes    int
+		want       window
+		wantErr    bool
+	}{
+		{want: window{since: 15 * time.Minute}},
+		{minutes: 5, want: window{since: 5 * time.Minute}},
+		{since: time.Hour, want: window{since: time.Hour}},
+		{start: "2016-05-23T10:00:00Z", want: window{start: at("2016-05-23T10:00:00Z")}},
+		{start: "2016-05-23T10:00:00Z", since: 10 * time.Minute,
+			want: window{start: at("2016-05-23T10:00:00Z"), end: at("2016-05-23T10:10:00Z"), since: 10 * time.Minute}},
+		{end: "2016-05-23T10:00:00Z", since: 10 * time.Minute, want: window{end: at("2016-05-23T10:00:00Z"), since: 10 * time.Minute}},
+		{start: "2016-05-23T10:00:00Z", end: "2016-05-23T10:05:00Z", since: time.Minute, wantErr: true},
+		{start: "2016-05-23T10:00:00Z", minutes: 5, wantErr: true},
+		{since: time.Minute, minutes: 5, wantErr: true},
+		{start: "2016-05-23T10:05:00Z", end: "2016-05-23T10:00:00Z", wantErr: true},
+		{start: "10:00", wantErr: true},
+		{end: "yesterday", wantErr: true},
+	} {
+		*startFlag, *endFlag, *since = c.start, c.end, c.since
+		minutes := c.minutes
+		if minutes == 0 {
+			minutes = -1
+		}
+		w, err := parseWindow(minutes)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("%+v: got %+v, want an error", c, w)
+			}
+			continue
+		}
+		if err != nil || !w.start.Equal(c.want.start) || !w.end.Equal(c.want.end) || w.since != c.want.since {
+			t.Errorf("%+v: got %+v, %v, want %+v", c, w, err, c.want)
+		}
+	}
+}
+
+func TestTimeGrid(t *testing.T) {
+	latest := time.Date(2016, 5, 23, 10, 0, 30, 0, time.UTC)
+	for _, c := range []struct {
+		start, end time.Duration //from latest
+		step       time.Duration
+		wantStart  time.Duration
+		wantLen    int
+	}{
+		// The grid ends at the latest timestamp and falls on its steps
+		{-5 * time.Minute, time.Minute, time.Minute, -5 * time.Minute, 6},
+		{-4*time.Minute - 40*time.Second, 0, time.Minute, -4 * time.Minute, 5},
+		// A window ending before the latest timestamp steps back onto it
+		{-10 * time.Minute, -150 * time.Second, time.Minute, -10 * time.Minute, 8},
+		{-10 * time.Minute, -3 * time.Minute, 2 * time.Minute, -10 * time.Minute, 4},
+		// No expected timestamp in the window
+		{-30 * time.Second, -10 * time.Second, time.Minute, -time.Minute, 0},
+	} {
+		grid := timeGrid(latest.Add(c.start), latest.Add(c.end), latest, c.step)
+		if grid.Len != c.wantLen || (grid.Len > 0 && !grid.Start.Equal(latest.Add(c.wantStart))) || grid.Step != c.step {
+			t.Errorf("[%v, %v] every %v: got %v from %v, want %v from %v", c.start, c.end, c.step,
+				grid.Len, grid.Start.Sub(latest), c.wantLen, c.wantStart)
+		}
+	}
+}
+
+func TestTimeLabels(t *testing.T) {
+	start := time.Date(2016, 5, 23, 10, 0, 30, 0, time.UTC)
+	for _, c := range []struct {
+		step  time.Duration
+		len   int
+		first string
+		last  string
+	}{
+		{time.Minute, 60, "00:30", "59:30"},
+		{time.Minute, 61, "10:00:30", "11:00:30"},
+		{time.Hour, 25, "10:00:30", "10:00:30"},
+		{time.Hour, 26, "05-23 10:00", "05-24 11:00"},
+	} {
+		labels := timeLabels(series.Grid{Start: start, Step: c.step, Len: c.len})
+		if len(labels) != c.len || labels[0] != c.first || labels[c.len-1] != c.last {
+			t.Errorf("%d steps of %v: got %v to %v, want %v to %v", c.len, c.step, labels[0], labels[len(labels)-1], c.first, c.last)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+//The query subcommand: querying the series kept with -store, without the cluster
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"./export"
+	"./query"
+	"./resample"
+	"./series"
+)
+
+// Parses the flags, which may come before, between or after the arguments,
+// as in query 'pod:cpu/usage_rate' -from 2h, and returns the arguments
+func parseInterleaved(args []string) []string {
+	var positional []string
+	for {
+		flag.CommandLine.Parse(args)
+		args = flag.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// Parses -from or -to: an RFC3339 time, a duration before now, or empty for an open end
+func parseQueryTime(name, value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid -%s %q, want an RFC3339 time or a duration ago", name, value)
+	}
+	return now.Add(-d), nil
+}
+
+// Evaluates a query over the store, prints its series and charts them if
+// a chart type is given: query [flags] <query> [<chart-type>]
+func runQuery(args []string) {
+	args = parseInterleaved(args)
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Print(usage)
+		os.Exit(1)
+	}
+	chartType := ""
+	if len(args) == 2 {
+		chartType = args[1]
+		if !chartTypes[chartType] {
+			fmt.Printf("Valid Chart types: spline/line/bar/column/area\n")
+			os.Exit(1)
+		}
+	}
+
+	expr, err := query.Parse(args[0])
+	if err == nil && *storeDir == "" {
+		err = fmt.Errorf("query needs the -store to read from")
+	}
+	now := time.Now()
+	var from, to time.Time
+	if err == nil {
+		from, err = parseQueryTime("from", *fromFlag, now)
+	}
+	if err == nil {
+		to, err = parseQueryTime("to", *toFlag, now)
+	}
+	if err == nil && !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		err = fmt.Errorf("-from %s is not before -to %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+	var fill series.Fill
+	if err == nil {
+		fill, err = series.ParseFill(*fillFlag)
+	}
+	var reducer resample.Reducer
+	if err == nil {
+		reducer, err = resample.ParseReducer(*reduceFlag)
+	}
+	var layout export.Layout
+	if err == nil {
+		layout, err = export.ParseLayout(*csvLayout)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	store, err := openStore(true)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	set, grid, err := expr.Eval(store, from, to, 0)
+	if err != nil {
+		fmt.Printf("Error: cannot read the store %s: %v\n", *storeDir, err)
+		os.Exit(1)
+	}
+	if len(set) == 0 {
+		fmt.Printf("No stored series match %s\n", expr)
+		return
+	}
+
+	out := &output{grid: grid, reducer: reducer, fill: fill, raw: *raw, layout: layout}
+	if *stepFlag > 0 {
+		out.grid = resample.Grid(grid, *stepFlag)
+	}
+	fmt.Printf("%s\n%s to %s, every %v\n", expr, grid.Start.Format(time.RFC3339), grid.End().Format(time.RFC3339), grid.Step)
+	for _, group := range set.GroupBy(series.LabelMetric) {
+		fmt.Printf("\nMetric Type: %s\n", group.Labels[series.LabelMetric])
+		for _, s := range scaleForPrint(group.Set, out.raw) {
+			labels := s.Labels.Copy()
+			delete(labels, series.LabelMetric)
+			fmt.Printf("%s: %s\n", labels, formatPoints(s, out.raw))
+		}
+	}
+
+	if chartType != "" {
+		generateCharts(set, chartType, out)
+	}
+	if *csvFile != "" {
+		writeCSV(set, out)
+	}
+}
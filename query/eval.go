@@ -0,0 +1,202 @@
+package query
+
+import (
+	"math"
+	"time"
+
+	"../derive"
+	"../resample"
+	"../series"
+	"../tsdb"
+)
+
+// Eval evaluates the query over the points stored in db in [from, to], a
+// zero from or to leaving that end open. The selected series are aligned to
+// a grid of step, or of the most common spacing of their points when 0,
+// ending at their latest point. Returns the result and its grid, which
+// *_over_time functions make coarser; no series when nothing matches.
+func (e *Expr) Eval(db *tsdb.DB, from, to time.Time, step time.Duration) (series.Set, series.Grid, error) {
+	selected := make(map[*selectorNode]series.Set)
+	var all series.Set
+	var err error
+	walk(e.root, func(n *selectorNode) {
+		if err != nil {
+			return
+		}
+		var set series.Set
+		if set, err = db.Query(n.matchers, from, to); err != nil {
+			return
+		}
+		if n.entityType != "" {
+			set = set.OfType(n.entityType)
+		}
+		selected[n] = set
+		all = append(all, set...)
+	})
+	if err != nil {
+		return nil, series.Grid{}, err
+	}
+
+	first, last := bounds(all)
+	if last.IsZero() {
+		return series.Set{}, series.Grid{}, nil
+	}
+	if step <= 0 {
+		step = spacing(all)
+	}
+	grid := series.NewGrid(first.Add(-step/2), last, step)
+	set, grid := eval(e.root, selected, grid)
+	return set, grid, nil
+}
+
+// Calls f for every selector of the tree
+func walk(n node, f func(*selectorNode)) {
+	switch n := n.(type) {
+	case *selectorNode:
+		f(n)
+	case *aggregateNode:
+		walk(n.arg, f)
+	case *callNode:
+		walk(n.arg, f)
+	}
+}
+
+func eval(n node, selected map[*selectorNode]series.Set, grid series.Grid) (series.Set, series.Grid) {
+	switch n := n.(type) {
+	case *selectorNode:
+		set := make(series.Set, len(selected[n]))
+		for i, s := range selected[n] {
+			set[i] = series.New(s.Labels, grid, s.Points)
+		}
+		return set, grid
+
+	case *callNode:
+		set, grid := eval(n.arg, selected, grid)
+		result := make(series.Set, len(set))
+		if n.fn == "rate" {
+			for i, s := range set {
+				result[i] = derive.Rate(s)
+				result[i].Labels = wrapMetric(n.fn, s.Labels)
+			}
+			return result, grid
+		}
+		reducer := resample.Avg
+		if n.fn == "max_over_time" {
+			reducer = resample.Max
+		}
+		windows := resample.Grid(grid, n.window)
+		for i, s := range set {
+			result[i] = withPoints(resample.Series(s, windows, reducer))
+			result[i].Labels = wrapMetric(n.fn, s.Labels)
+		}
+		return result, windows
+
+	case *aggregateNode:
+		set, grid := eval(n.arg, selected, grid)
+		result := make(series.Set, 0)
+		for _, group := range set.GroupBy(n.by...) {
+			result = append(result, aggregate(n.op, group.Set, grid))
+		}
+		return result, grid
+	}
+	panic("query: unknown node")
+}
+
+// Combines the series of a group slot by slot, leaving out missing values
+// The result has the labels the series all share, and their unit if they share it
+func aggregate(op string, set series.Set, grid series.Grid) *series.Series {
+	labels := set[0].Labels.Copy()
+	unit := set[0].Unit
+	for _, s := range set[1:] {
+		for name, value := range labels {
+			if s.Labels[name] != value {
+				delete(labels, name)
+			}
+		}
+		if s.Unit != unit {
+			unit = series.None
+		}
+	}
+
+	result := &series.Series{Labels: wrapMetric(op, labels), Unit: unit, Grid: grid, Values: make([]float64, grid.Len)}
+	for i := range result.Values {
+		combined, count := 0.0, 0
+		for _, s := range set {
+			v := s.Values[i]
+			if series.IsMissing(v) {
+				continue
+			}
+			switch {
+			case count == 0:
+				combined = v
+			case op == "max":
+				combined = math.Max(combined, v)
+			default:
+				combined += v
+			}
+			count++
+		}
+		switch {
+		case count == 0:
+			combined = series.Missing()
+		case op == "avg":
+			combined /= float64(count)
+		}
+		result.Values[i] = combined
+	}
+	return withPoints(result)
+}
+
+// Replaces the points of s with its values that are not missing
+func withPoints(s *series.Series) *series.Series {
+	s.Points = nil
+	for i, v := range s.Values {
+		if !series.IsMissing(v) {
+			s.Points = append(s.Points, series.Point{Time: s.Grid.Time(i), Value: v})
+		}
+	}
+	return s
+}
+
+// Returns labels with the metric wrapped in fn, e.g. rate(cpu/usage)
+func wrapMetric(fn string, labels series.Labels) series.Labels {
+	metric, ok := labels[series.LabelMetric]
+	if !ok {
+		return labels
+	}
+	return labels.Copy(series.LabelMetric, fn+"("+metric+")")
+}
+
+// Returns the times of the earliest and latest points of set
+func bounds(set series.Set) (time.Time, time.Time) {
+	var first, last time.Time
+	for _, s := range set {
+		if len(s.Points) == 0 {
+			continue
+		}
+		if t := s.Points[0].Time; first.IsZero() || t.Before(first) {
+			first = t
+		}
+		if t := s.Points[len(s.Points)-1].Time; t.After(last) {
+			last = t
+		}
+	}
+	return first, last
+}
+
+// Returns the most common interval between consecutive points of the series
+// of set, the smallest one on a tie, or a minute, Heapster's default
+// resolution, if no series has two points
+func spacing(set series.Set) time.Duration {
+	lists := make([][]time.Time, len(set))
+	for i, s := range set {
+		lists[i] = make([]time.Time, len(s.Points))
+		for j, p := range s.Points {
+			lists[i][j] = p.Time
+		}
+	}
+	if d := series.Spacing(lists...); d > 0 {
+		return d
+	}
+	return time.Minute
+}
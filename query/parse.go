@@ -0,0 +1,288 @@
+// Package query evaluates queries over the series kept in a tsdb store, such as
+//
+//	sum by (namespace) (rate(pod{namespace=~"web|api"}:cpu/usage))
+//	max_over_time(pod{namespace="web",name=~"api-.*"}:cpu/usage_rate[5m])
+package query
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"../series"
+	"../tsdb"
+)
+
+// Expr is a parsed query
+type Expr struct {
+	text string
+	root node
+}
+
+// A node of the query tree
+type node interface{}
+
+// Series of an entity type matching label matchers, e.g. pod{namespace="web"}:cpu/usage_rate
+// The type and the metric are optional
+type selectorNode struct {
+	entityType string
+	matchers   []*tsdb.Matcher
+}
+
+// sum, avg or max across the series that share the values of the by labels
+type aggregateNode struct {
+	op  string
+	by  []string
+	arg node
+}
+
+// rate, or avg_over_time or max_over_time over windows of the given length
+type callNode struct {
+	fn     string
+	window time.Duration
+	arg    node
+}
+
+// Aggregations and functions
+var (
+	aggregations = map[string]bool{"sum": true, "avg": true, "max": true}
+	functions    = map[string]bool{"rate": true, "avg_over_time": true, "max_over_time": true}
+)
+
+// Label each entity type's name matcher stands for
+var nameLabels = map[string]string{
+	series.TypeCluster:       series.LabelCluster,
+	series.TypeNode:          series.LabelNode,
+	series.TypeNamespace:     series.LabelNamespace,
+	series.TypePod:           series.LabelPod,
+	series.TypeContainer:     series.LabelContainer,
+	series.TypeFreeContainer: series.LabelContainer,
+}
+
+// Parse parses a query: a selector, an entity type followed by label matchers
+// between braces and a metric after a colon, each optional, or one of
+//
+//	sum|avg|max [by (label, ...)] (query)
+//	rate(query)
+//	avg_over_time(query[duration]), max_over_time(query[duration])
+//
+// The name label of a selector stands for the label naming its entity type,
+// e.g. pod for pods.
+func Parse(text string) (*Expr, error) {
+	p := &parser{text: text}
+	root, err := p.expr()
+	if err == nil && p.skipSpace() < len(text) {
+		err = fmt.Errorf("unexpected %q", text[p.pos:])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid query %q: %v", text, err)
+	}
+	return &Expr{text: text, root: root}, nil
+}
+
+// String returns the query as it was given
+func (e *Expr) String() string {
+	return e.text
+}
+
+type parser struct {
+	text string
+	pos  int
+}
+
+// Skips spaces and returns the position of the next character
+func (p *parser) skipSpace() int {
+	for p.pos < len(p.text) && p.text[p.pos] == ' ' {
+		p.pos++
+	}
+	return p.pos
+}
+
+// Reports whether the next character is c, and consumes it if so
+func (p *parser) accept(c byte) bool {
+	if p.skipSpace() < len(p.text) && p.text[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(c byte) error {
+	if !p.accept(c) {
+		if p.pos >= len(p.text) {
+			return fmt.Errorf("want %q at the end", c)
+		}
+		return fmt.Errorf("want %q at %q", c, p.text[p.pos:])
+	}
+	return nil
+}
+
+// Reads a run of the characters ok accepts
+func (p *parser) word(ok func(c byte) bool) string {
+	start := p.skipSpace()
+	for p.pos < len(p.text) && ok(p.text[p.pos]) {
+		p.pos++
+	}
+	return p.text[start:p.pos]
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+func isMetricChar(c byte) bool {
+	return isIdentChar(c) || c == '/' || c == '.' || c == '-'
+}
+
+// expr := aggregation | function | selector
+func (p *parser) expr() (node, error) {
+	start := p.pos
+	word := p.word(isIdentChar)
+	switch {
+	case aggregations[word]:
+		return p.aggregation(word)
+	case functions[word]:
+		return p.call(word)
+	}
+	p.pos = start
+	return p.selector()
+}
+
+// aggregation := op ["by" labels] "(" expr ")" ["by" labels]
+func (p *parser) aggregation(op string) (node, error) {
+	n := &aggregateNode{op: op}
+	by, err := p.by()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	if n.arg, err = p.expr(); err != nil {
+		return nil, err
+	}
+	if err := p.expect(')'); err != nil {
+		return nil, err
+	}
+	if by == nil {
+		if by, err = p.by(); err != nil {
+			return nil, err
+		}
+	}
+	n.by = by
+	return n, nil
+}
+
+// Reads an optional "by" "(" label {"," label} ")"
+func (p *parser) by() ([]string, error) {
+	start := p.pos
+	if p.word(isIdentChar) != "by" {
+		p.pos = start
+		return nil, nil
+	}
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	labels := make([]string, 0)
+	for !p.accept(')') {
+		if len(labels) > 0 {
+			if err := p.expect(','); err != nil {
+				return nil, err
+			}
+		}
+		label := p.word(isIdentChar)
+		if label == "" {
+			return nil, fmt.Errorf("want a label name in by()")
+		}
+		labels = append(labels, label)
+	}
+	return labels, nil
+}
+
+// call := "rate" "(" expr ")" | fn "(" expr "[" duration "]" ")"
+func (p *parser) call(fn string) (node, error) {
+	n := &callNode{fn: fn}
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	var err error
+	if n.arg, err = p.expr(); err != nil {
+		return nil, err
+	}
+	if fn != "rate" {
+		if err := p.expect('['); err != nil {
+			return nil, fmt.Errorf("%s needs a window, e.g. %s(pod:cpu/usage_rate[5m])", fn, fn)
+		}
+		text := p.word(func(c byte) bool { return c != ']' })
+		if n.window, err = time.ParseDuration(strings.TrimSpace(text)); err != nil || n.window <= 0 {
+			return nil, fmt.Errorf("invalid window %q of %s", text, fn)
+		}
+		if err := p.expect(']'); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect(')'); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// selector := [type] ["{" matchers "}"] [":" metric]
+func (p *parser) selector() (node, error) {
+	n := &selectorNode{entityType: p.word(isIdentChar)}
+	if _, ok := nameLabels[n.entityType]; n.entityType != "" && !ok {
+		return nil, fmt.Errorf("unknown entity type or function %q", n.entityType)
+	}
+	if p.skipSpace() < len(p.text) && p.text[p.pos] == '{' {
+		end, err := p.closingBrace()
+		if err != nil {
+			return nil, err
+		}
+		matchers, err := tsdb.ParseSelector(p.text[p.pos:end])
+		if err != nil {
+			return nil, err
+		}
+		p.pos = end
+		for _, m := range matchers {
+			if m.Name != "name" {
+				continue
+			}
+			if n.entityType == "" {
+				return nil, fmt.Errorf("the name label needs an entity type, e.g. pod{name=%q}", m.Value)
+			}
+			m.Name = nameLabels[n.entityType]
+		}
+		n.matchers = matchers
+	}
+	if p.accept(':') {
+		metric := p.word(isMetricChar)
+		if metric == "" {
+			return nil, fmt.Errorf("want a metric after ':'")
+		}
+		m, _ := tsdb.NewMatcher(tsdb.MatchEqual, series.LabelMetric, metric)
+		n.matchers = append(n.matchers, m)
+	}
+	if n.entityType == "" && len(n.matchers) == 0 {
+		if p.pos >= len(p.text) {
+			return nil, fmt.Errorf("want a selector at the end")
+		}
+		return nil, fmt.Errorf("want a selector at %q", p.text[p.pos:])
+	}
+	return n, nil
+}
+
+// Returns the position after the brace closing the one at p.pos, skipping quoted values
+func (p *parser) closingBrace() (int, error) {
+	quoted := false
+	for i := p.pos; i < len(p.text); i++ {
+		switch c := p.text[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case !quoted && c == '}':
+			return i + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("unclosed {")
+}
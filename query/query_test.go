@@ -0,0 +1,138 @@
+package query
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"../series"
+	"../seriestest"
+	"../tsdb"
+)
+
+var start = seriestest.Start
+
+// A series of a pod metric with a point per minute from start
+func pod(ns, name, metric string, values ...float64) *series.Series {
+	return seriestest.Minutes(seriestest.Pod(ns, name, metric), 0, values...)
+}
+
+// Returns a store holding three pods and a node over 6 minutes
+func testStore(t *testing.T) *tsdb.DB {
+	dir, err := ioutil.TempDir("", "query")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	db, err := tsdb.Open(dir, tsdb.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := series.Missing()
+	node := pod("", "", "cpu/usage_rate", 1000, 1000, 1000, 1000, 1000, 1000)
+	node.Labels = series.Labels{series.LabelCluster: "k8s-cluster", series.LabelNode: "node-1", series.LabelMetric: "cpu/usage_rate"}
+	db.Append(series.Set{
+		pod("web", "api-1", "cpu/usage_rate", 100, 200, 300, 400, 500, 600),
+		pod("web", "api-2", "cpu/usage_rate", 10, 20, m, 40, 50, 60),
+		pod("web", "cache-1", "cpu/usage_rate", 1, 2, 3, 4, 5, 6),
+		pod("db", "api-1", "cpu/usage_rate", 7, 7, 7, 7, 7, 7),
+		pod("web", "api-1", "cpu/usage", 0, 60e9, 120e9, 180e9, 240e9, 300e9),
+		node,
+	})
+	return db
+}
+
+func run(t *testing.T, db *tsdb.DB, text string, from, to time.Time) (series.Set, series.Grid) {
+	e, err := Parse(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, grid, err := e.Eval(db, from, to, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return set, grid
+}
+
+func TestSelector(t *testing.T) {
+	db := testStore(t)
+	set, grid := run(t, db, `pod{namespace="web",name=~"api-.*"}:cpu/usage_rate`, start.Add(time.Minute), start.Add(4*time.Minute))
+	if len(set) != 2 || grid.Step != time.Minute || grid.Len != 4 || !grid.Start.Equal(start.Add(time.Minute)) {
+		t.Fatalf("got %d series on %+v, want 2 on 4 minutes from 10:01", len(set), grid)
+	}
+	if got, want := set[1].Values, []float64{20, series.Missing(), 40, 50}; set[1].Labels[series.LabelPod] != "api-2" || !seriestest.Equal(got, want) {
+		t.Errorf("%s = %v, want %v", set[1].Labels, got, want)
+	}
+
+	// Without an entity type, nodes match too
+	if set, _ := run(t, db, `:cpu/usage_rate`, time.Time{}, time.Time{}); len(set) != 5 {
+		t.Errorf("got %d series, want 5", len(set))
+	}
+	if set, _ := run(t, db, `node`, time.Time{}, time.Time{}); len(set) != 1 {
+		t.Errorf("got %d node series, want 1", len(set))
+	}
+	if set, _ := run(t, db, `pod{name="none"}`, time.Time{}, time.Time{}); len(set) != 0 {
+		t.Errorf("got %d series, want none", len(set))
+	}
+}
+
+func TestAggregations(t *testing.T) {
+	db := testStore(t)
+	m := series.Missing()
+	tests := []struct {
+		query  string
+		labels series.Labels
+		want   []float64
+	}{
+		{`sum by (namespace) (pod{namespace!="db"}:cpu/usage_rate)`, series.Labels{series.LabelCluster: "k8s-cluster", series.LabelNamespace: "web", series.LabelMetric: "sum(cpu/usage_rate)"}, []float64{111, 222, 303, 444, 555, 666}},
+		{`avg(pod{namespace="web",name=~"api-.*"}:cpu/usage_rate) by (namespace)`, nil, []float64{55, 110, 300, 220, 275, 330}},
+		{`max(pod:cpu/usage_rate)`, nil, []float64{100, 200, 300, 400, 500, 600}},
+		{`rate(pod:cpu/usage)`, series.Labels{series.LabelCluster: "k8s-cluster", series.LabelNamespace: "web", series.LabelPod: "api-1", series.LabelMetric: "rate(cpu/usage)"}, []float64{m, 1000, 1000, 1000, 1000, 1000}},
+		{`avg_over_time(pod{namespace="web",name="api-2"}:cpu/usage_rate[3m])`, nil, []float64{15, 50}},
+		{`max_over_time(pod{namespace="web",name="api-1"}:cpu/usage_rate[2m])`, nil, []float64{200, 400, 600}},
+		{`sum by (namespace) (max_over_time(pod{namespace!="db"}:cpu/usage_rate[3m]))`, nil, []float64{323, 666}},
+	}
+	for _, test := range tests {
+		set, _ := run(t, db, test.query, time.Time{}, time.Time{})
+		if len(set) == 0 {
+			t.Errorf("%s: no series", test.query)
+			continue
+		}
+		if !seriestest.Equal(set[0].Values, test.want) {
+			t.Errorf("%s = %v, want %v", test.query, set[0].Values, test.want)
+		}
+		if test.labels != nil && set[0].Labels.String() != test.labels.String() {
+			t.Errorf("%s: labels %s, want %s", test.query, set[0].Labels, test.labels)
+		}
+	}
+}
+
+func TestUnits(t *testing.T) {
+	db := testStore(t)
+	if set, _ := run(t, db, `sum(pod:cpu/usage_rate)`, time.Time{}, time.Time{}); set[0].Unit != series.Millicores {
+		t.Errorf("sum unit %q, want millicores", set[0].Unit)
+	}
+	if set, _ := run(t, db, `sum(pod{name="api-1"})`, time.Time{}, time.Time{}); set[0].Unit != series.None {
+		t.Errorf("sum of different units in %q", set[0].Unit)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, text := range []string{
+		``,
+		`pods:cpu/usage_rate`,
+		`{name="api-1"}`,
+		`pod{namespace="web"`,
+		`pod:`,
+		`sum by namespace (pod)`,
+		`sum(pod`,
+		`avg_over_time(pod:cpu/usage_rate)`,
+		`max_over_time(pod[five])`,
+		`rate(pod) pod`,
+	} {
+		if _, err := Parse(text); err == nil {
+			t.Errorf("%q: no error", text)
+		}
+	}
+}
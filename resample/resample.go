@@ -0,0 +1,128 @@
+// Resampling of series onto coarser or finer grids
+
+package resample
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"../series"
+)
+
+// Reducer combines the values falling into one slot of the new grid
+type Reducer string
+
+// Reducers
+const (
+	Avg  Reducer = "avg"
+	Min  Reducer = "min"
+	Max  Reducer = "max"
+	Last Reducer = "last"
+	Sum  Reducer = "sum"
+)
+
+// Reducers lists the valid reducers
+var Reducers = []Reducer{Avg, Min, Max, Last, Sum}
+
+// ParseReducer returns the named reducer
+func ParseReducer(name string) (Reducer, error) {
+	for _, r := range Reducers {
+		if string(r) == name {
+			return r, nil
+		}
+	}
+	return "", fmt.Errorf("unknown reducer %q, want one of %v", name, Reducers)
+}
+
+// reduce combines values, none of which are missing
+func (r Reducer) reduce(values []float64) float64 {
+	if len(values) == 0 {
+		return series.Missing()
+	}
+	v := values[0]
+	for _, x := range values[1:] {
+		switch r {
+		case Min:
+			v = math.Min(v, x)
+		case Max:
+			v = math.Max(v, x)
+		case Last:
+			v = x
+		default:
+			v += x
+		}
+	}
+	if r == Avg {
+		v /= float64(len(values))
+	}
+	return v
+}
+
+// Grid returns the grid with the given step that ends where g ends and
+// covers the same time range
+func Grid(g series.Grid, step time.Duration) series.Grid {
+	return series.NewGrid(g.Start.Add(-g.Step).Add(step), g.End(), step)
+}
+
+// Series returns s resampled onto grid.
+//
+// Each grid timestamp ends a slot, like Heapster's sample timestamps end the
+// interval they cover, and gets the values of s in (t-step, t] combined by r.
+// When grid is finer than the grid of s, a slot that has no value of its own
+// gets the value of the sample covering it, so series of different resolutions
+// can be compared on a shared grid. Slots without any value are missing.
+func Series(s *series.Series, grid series.Grid, r Reducer) *series.Series {
+	resampled := *s
+	resampled.Grid = grid
+	resampled.Values = make([]float64, grid.Len)
+
+	src := s.Grid
+	buckets := make([][]float64, grid.Len)
+	for j, v := range s.Values {
+		if series.IsMissing(v) {
+			continue
+		}
+		if i, ok := slot(grid, src.Time(j)); ok {
+			buckets[i] = append(buckets[i], v)
+		}
+	}
+	for i, values := range buckets {
+		if len(values) == 0 && grid.Step < src.Step {
+			values = covering(s, grid.Time(i))
+		}
+		resampled.Values[i] = r.reduce(values)
+	}
+	return &resampled
+}
+
+// slot returns the index of the grid slot (t-step, t] containing t
+func slot(grid series.Grid, t time.Time) (int, bool) {
+	offset := t.Sub(grid.Start)
+	if offset <= -grid.Step {
+		return 0, false
+	}
+	i := 0
+	if offset > 0 {
+		i = int((offset + grid.Step - 1) / grid.Step)
+	}
+	return i, i < grid.Len
+}
+
+// covering returns the value of s whose sample interval contains t, if any
+func covering(s *series.Series, t time.Time) []float64 {
+	j, ok := slot(s.Grid, t)
+	if !ok || series.IsMissing(s.Values[j]) {
+		return nil
+	}
+	return []float64{s.Values[j]}
+}
+
+// All resamples every series onto grid
+func All(set series.Set, grid series.Grid, r Reducer) series.Set {
+	resampled := make(series.Set, len(set))
+	for i, s := range set {
+		resampled[i] = Series(s, grid, r)
+	}
+	return resampled
+}
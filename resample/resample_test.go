@@ -0,0 +1,70 @@
+package resample
+
+import (
+	"testing"
+	"time"
+
+	"../series"
+	"../seriestest"
+)
+
+var start = seriestest.Start
+
+// A node series with a value per minute from start
+func minuteSeries(values ...float64) *series.Series {
+	return seriestest.Aligned(series.Labels{series.LabelNode: "node-1", series.LabelMetric: "cpu/usage_rate"}, values...)
+}
+
+func TestDownsample(t *testing.T) {
+	m := series.Missing()
+	s := minuteSeries(1, 2, 3, 4, m, 6)
+	grid := Grid(s.Grid, 2*time.Minute)
+	if grid.Len != 3 || !grid.End().Equal(s.Grid.End()) {
+		t.Fatalf("grid = %+v, want 3 slots ending at %v", grid, s.Grid.End())
+	}
+
+	tests := map[Reducer][]float64{
+		Avg:  {1.5, 3.5, 6},
+		Min:  {1, 3, 6},
+		Max:  {2, 4, 6},
+		Last: {2, 4, 6},
+		Sum:  {3, 7, 6},
+	}
+	for r, want := range tests {
+		got := Series(s, grid, r).Values
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s: got %v, want %v", r, got, want)
+				break
+			}
+		}
+	}
+}
+
+func TestDownsampleEmptySlot(t *testing.T) {
+	m := series.Missing()
+	s := minuteSeries(1, 2, m, m)
+	got := Series(s, Grid(s.Grid, 2*time.Minute), Avg).Values
+	if len(got) != 2 || got[0] != 1.5 || !series.IsMissing(got[1]) {
+		t.Errorf("got %v, want [1.5 NaN]", got)
+	}
+}
+
+func TestUpsample(t *testing.T) {
+	s := minuteSeries(1, 2)
+	grid := Grid(s.Grid, 30*time.Second)
+	got := Series(s, grid, Avg).Values
+	// 09:59:30 is covered by the 10:00 sample, 10:00:30 by the 10:01 one
+	want := []float64{1, 1, 2, 2}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+	if !grid.Start.Equal(start.Add(-30 * time.Second)) {
+		t.Errorf("grid starts at %v, want %v", grid.Start, start.Add(-30*time.Second))
+	}
+}
@@ -0,0 +1,71 @@
+//Detecting the Heapster resolution instead of relying on the command line
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"./heapster"
+	"./kube"
+	"./series"
+)
+
+// Returns the most common interval between consecutive points, see
+// series.Spacing, or 0 with fewer than two points
+func resolutionFromSpacing(points []heapster.MetricPoint) time.Duration {
+	times := make([]time.Time, len(points))
+	for i, p := range points {
+		times[i] = p.Timestamp
+	}
+	return series.Spacing(times)
+}
+
+// Returns the --metric_resolution flag of the Heapster pods, found through the
+// Kubernetes API by their k8s-app=<service> label, or 0 if no pod sets it
+func resolutionFromFlags(ctx context.Context, kc *kube.Client, namespace, service string) (time.Duration, error) {
+	pods, err := kc.ListPods(ctx, namespace, "k8s-app="+service)
+	if err != nil {
+		return 0, err
+	}
+	for _, pod := range pods {
+		for _, c := range pod.Spec.Containers {
+			value, ok := c.Flag("metric_resolution")
+			if !ok {
+				continue
+			}
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return 0, fmt.Errorf("pod %s: invalid --metric_resolution %q", pod.Metadata.Name, value)
+			}
+			return d, nil
+		}
+	}
+	return 0, nil
+}
+
+// Returns the resolution to collect at: the one inferred from the spacing of the
+// probe points, or else from Heapster's flags, or else the user's
+// A user resolution that disagrees with the inferred one is replaced, with a warning
+func detectResolution(ctx context.Context, kc *kube.Client, probe *heapster.MetricResult, user time.Duration) (time.Duration, error) {
+	inferred, source := resolutionFromSpacing(probe.Metrics), "the spacing of Heapster's timestamps"
+	if inferred == 0 {
+		var err error
+		inferred, err = resolutionFromFlags(ctx, kc, *heapsterNamespace, *heapsterService)
+		if err != nil && user == 0 {
+			return 0, fmt.Errorf("cannot detect the Heapster resolution, give it as the first argument: %v", err)
+		}
+		source = "Heapster's --metric_resolution flag"
+	}
+
+	switch {
+	case inferred == 0 && user == 0:
+		return 0, fmt.Errorf("cannot detect the Heapster resolution, give it as the first argument")
+	case inferred == 0:
+		return user, nil
+	case user != 0 && user != inferred:
+		fmt.Printf("Warning: resolution %v given, but %s says %v; using %v\n", user, source, inferred, inferred)
+	}
+	return inferred, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"./heapster"
+	"./kube"
+)
+
+// Points at the given offsets in seconds from a fixed time
+func pointsAt(offsets ...int) []heapster.MetricPoint {
+	base := time.Date(2016, 5, 23, 10, 0, 0, 0, time.UTC)
+	points := make([]heapster.MetricPoint, len(offsets))
+	for i, s := range offsets {
+		points[i] = heapster.MetricPoint{Timestamp: base.Add(time.Duration(s) * time.Second)}
+	}
+	return points
+}
+
+func TestResolutionFromSpacing(t *testing.T) {
+	for _, c := range []struct {
+		offsets []int
+		want    time.Duration
+	}{
+		{nil, 0},
+		{[]int{0}, 0},
+		{[]int{0, 60, 120, 180}, time.Minute},
+		// A missing point does not change the most common spacing
+		{[]int{0, 30, 60, 120, 150}, 30 * time.Second},
+		{[]int{0, 15, 30, 45, 60}, 15 * time.Second},
+	} {
+		if got := resolutionFromSpacing(pointsAt(c.offsets...)); got != c.want {
+			t.Errorf("%v: got %v, want %v", c.offsets, got, c.want)
+		}
+	}
+}
+
+func TestDetectResolution(t *testing.T) {
+	// The API server returns the Heapster pods with this flag, or fails if empty
+	var flag string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if flag == "" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"items": [{"metadata": {"name": "heapster-1"},
+		  "spec": {"containers": [{"name": "heapster", "command": ["/heapster", "` + flag + `"]}]}}]}`))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+	api := heapster.NewClient(server.URL)
+	api.Retries = 0
+	kc := kube.NewClient(api)
+
+	for _, c := range []struct {
+		name    string
+		offsets []int
+		flag    string
+		user    time.Duration
+		want    time.Duration
+		wantErr bool
+	}{
+		{"spacing", []int{0, 30, 60}, "", 0, 30 * time.Second, false},
+		{"spacing over user", []int{0, 30, 60}, "", time.Minute, 30 * time.Second, false},
+		{"flag", []int{0}, "--metric_resolution=15s", 0, 15 * time.Second, false},
+		{"flag over user", []int{0}, "--metric_resolution=15s", time.Minute, 15 * time.Second, false},
+		{"no flag", []int{0}, "--source=kubernetes", time.Minute, time.Minute, false},
+		{"no flag or user", []int{0}, "--source=kubernetes", 0, 0, true},
+		{"unreadable flags", []int{0}, "", time.Minute, time.Minute, false},
+		{"unreadable flags, no user", []int{0}, "", 0, 0, true},
+		{"invalid flag", []int{0}, "--metric_resolution=often", time.Minute, time.Minute, false},
+	} {
+		flag = c.flag
+		probe := &heapster.MetricResult{Metrics: pointsAt(c.offsets...)}
+		got, err := detectResolution(context.Background(), kc, probe, c.user)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("%s: got %v, want an error", c.name, got)
+			}
+			continue
+		}
+		if err != nil || got != c.want {
+			t.Errorf("%s: got %v, %v, want %v", c.name, got, err, c.want)
+		}
+	}
+}
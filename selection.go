@@ -0,0 +1,74 @@
+//Narrowing down the nodes and pods to collect, by label selector or by name
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"./kube"
+)
+
+// Include/exclude regexes on entity names
+// An empty include matches every name, an empty exclude none
+type nameFilter struct {
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+}
+
+func newNameFilter(include, exclude string) (*nameFilter, error) {
+	f := &nameFilter{}
+	var err error
+	if include != "" {
+		if f.include, err = regexp.Compile(include); err != nil {
+			return nil, fmt.Errorf("invalid include regex %q: %v", include, err)
+		}
+	}
+	if exclude != "" {
+		if f.exclude, err = regexp.Compile(exclude); err != nil {
+			return nil, fmt.Errorf("invalid exclude regex %q: %v", exclude, err)
+		}
+	}
+	return f, nil
+}
+
+func (f *nameFilter) match(name string) bool {
+	if f.include != nil && !f.include.MatchString(name) {
+		return false
+	}
+	return f.exclude == nil || !f.exclude.MatchString(name)
+}
+
+// Returns the names matching the filter, keeping their order
+func (f *nameFilter) filter(names []string) []string {
+	matched := make([]string, 0, len(names))
+	for _, name := range names {
+		if f.match(name) {
+			matched = append(matched, name)
+		}
+	}
+	return matched
+}
+
+// Returns the pods among names whose labels match selector, asking the
+// Kubernetes API for the matching pods of the namespace
+// Pods the API returns but Heapster does not know about are left out
+func selectPodsByLabel(ctx context.Context, kc *kube.Client, namespace, selector string, names []string) ([]string, error) {
+	pods, err := kc.ListPods(ctx, namespace, selector)
+	if err != nil {
+		return nil, err
+	}
+	selected := make(map[string]bool)
+	for _, pod := range pods {
+		selected[pod.Metadata.Name] = true
+	}
+
+	matched := make([]string, 0, len(names))
+	for _, name := range names {
+		if selected[name] {
+			matched = append(matched, name)
+		}
+	}
+	return matched, nil
+}
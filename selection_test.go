@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"./heapster"
+	"./kube"
+)
+
+func TestNameFilter(t *testing.T) {
+	names := []string{"api-1", "api-2", "api-10", "db-0", "web-api"}
+	for _, c := range []struct {
+		include, exclude string
+		want             []string
+	}{
+		{"", "", names},
+		{"^api-1$", "", []string{"api-1"}},
+		{"^api-", "", []string{"api-1", "api-2", "api-10"}},
+		{"api", "", []string{"api-1", "api-2", "api-10", "web-api"}},
+		{"", "^db-0$", []string{"api-1", "api-2", "api-10", "web-api"}},
+		{"^api-", "^api-1", []string{"api-2"}},
+		{"^cache-", "", []string{}},
+		{"", ".", []string{}},
+	} {
+		f, err := newNameFilter(c.include, c.exclude)
+		if err != nil {
+			t.Errorf("include %q, exclude %q: unexpected error: %v", c.include, c.exclude, err)
+			continue
+		}
+		if got := f.filter(names); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("include %q, exclude %q: got %v, want %v", c.include, c.exclude, got, c.want)
+		}
+	}
+
+	for _, c := range [][2]string{{"api-[", ""}, {"", "(db"}} {
+		if _, err := newNameFilter(c[0], c[1]); err == nil {
+			t.Errorf("include %q, exclude %q: want an error", c[0], c[1])
+		}
+	}
+}
+
+func TestSelectPodsByLabel(t *testing.T) {
+	// The pods of namespace web the API server returns for each selector
+	// cache-0 is not known to Heapster
+	matching := map[string][]string{
+		"app=api":              {"api-1", "api-2"},
+		"app!=api":             {"db-0", "cache-0"},
+		"tier in (back,cache)": {"db-0", "cache-0"},
+		"app notin (api,db)":   {"cache-0"},
+		"app=none":             nil,
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/web/pods" {
+			http.NotFound(w, r)
+			return
+		}
+		pods, ok := matching[r.URL.Query().Get("labelSelector")]
+		if !ok {
+			http.Error(w, "unexpected selector", http.StatusBadRequest)
+			return
+		}
+		var items []string
+		for _, pod := range pods {
+			items = append(items, fmt.Sprintf(`{"metadata": {"name": %q}}`, pod))
+		}
+		fmt.Fprintf(w, `{"items": [%s]}`, strings.Join(items, ","))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+	api := heapster.NewClient(server.URL)
+	api.Retries = 0
+	kc := kube.NewClient(api)
+
+	names := []string{"api-2", "api-1", "db-0"}
+	for _, c := range []struct {
+		selector string
+		want     []string
+	}{
+		{"app=api", []string{"api-2", "api-1"}},
+		{"app!=api", []string{"db-0"}},
+		{"tier in (back,cache)", []string{"db-0"}},
+		{"app notin (api,db)", []string{}},
+		{"app=none", []string{}},
+	} {
+		got, err := selectPodsByLabel(context.Background(), kc, "web", c.selector, names)
+		if err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, %v, want %v", c.selector, got, err, c.want)
+		}
+	}
+
+	if got, err := selectPodsByLabel(context.Background(), kc, "web", "app==", names); err == nil {
+		t.Errorf("invalid selector: got %v, want an error", got)
+	}
+}
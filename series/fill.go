@@ -0,0 +1,79 @@
+package series
+
+import (
+	"fmt"
+	"math"
+)
+
+// Missing returns the value of a missing point. A series holds it in every
+// grid slot no point was aligned to.
+func Missing() float64 {
+	return math.NaN()
+}
+
+// Fill is a strategy for outputting the gaps of a series
+type Fill string
+
+// Gap filling strategies
+const (
+	// FillOmit leaves missing points out of the output
+	FillOmit Fill = "omit"
+	// FillNull outputs missing points as null, so charts break the line
+	FillNull Fill = "null"
+	// FillPrevious repeats the last value before a gap
+	FillPrevious Fill = "previous"
+	// FillLinear interpolates between the values around a gap
+	FillLinear Fill = "linear"
+	// FillZero outputs missing points as 0
+	FillZero Fill = "zero"
+)
+
+// Fills lists the valid strategies
+var Fills = []Fill{FillOmit, FillNull, FillPrevious, FillLinear, FillZero}
+
+// ParseFill returns the named strategy
+func ParseFill(name string) (Fill, error) {
+	for _, f := range Fills {
+		if string(f) == name {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown fill %q, want one of %v", name, Fills)
+}
+
+// Apply returns a copy of values with gaps filled. Gaps that cannot be
+// filled, such as those before the first value with FillPrevious or at
+// either end with FillLinear, stay missing, as do all gaps with FillOmit and
+// FillNull.
+func (f Fill) Apply(values []float64) []float64 {
+	filled := append([]float64(nil), values...)
+	switch f {
+	case FillZero:
+		for i, v := range filled {
+			if IsMissing(v) {
+				filled[i] = 0
+			}
+		}
+	case FillPrevious:
+		for i := 1; i < len(filled); i++ {
+			if IsMissing(filled[i]) {
+				filled[i] = filled[i-1]
+			}
+		}
+	case FillLinear:
+		prev := -1
+		for i, v := range filled {
+			if IsMissing(v) {
+				continue
+			}
+			if prev >= 0 && i-prev > 1 {
+				step := (v - filled[prev]) / float64(i-prev)
+				for j := prev + 1; j < i; j++ {
+					filled[j] = filled[prev] + step*float64(j-prev)
+				}
+			}
+			prev = i
+		}
+	}
+	return filled
+}
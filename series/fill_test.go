@@ -0,0 +1,52 @@
+package series
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFillApply(t *testing.T) {
+	m := Missing()
+	values := []float64{m, 1, m, m, 4, m}
+
+	tests := []struct {
+		fill Fill
+		want []float64
+	}{
+		{FillOmit, []float64{m, 1, m, m, 4, m}},
+		{FillNull, []float64{m, 1, m, m, 4, m}},
+		{FillZero, []float64{0, 1, 0, 0, 4, 0}},
+		{FillPrevious, []float64{m, 1, 1, 1, 4, 4}},
+		{FillLinear, []float64{m, 1, 2, 3, 4, m}},
+	}
+	for _, tt := range tests {
+		got := tt.fill.Apply(values)
+		if !equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.fill, got, tt.want)
+		}
+	}
+	if !IsMissing(values[2]) {
+		t.Errorf("Apply modified its input: %v", values)
+	}
+}
+
+func TestParseFill(t *testing.T) {
+	if f, err := ParseFill("linear"); err != nil || f != FillLinear {
+		t.Errorf("ParseFill(linear) = %q, %v", f, err)
+	}
+	if _, err := ParseFill("spline"); err == nil {
+		t.Error("ParseFill(spline) succeeded")
+	}
+}
+
+func equal(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if IsMissing(a[i]) != IsMissing(b[i]) || !IsMissing(a[i]) && math.Abs(a[i]-b[i]) > 1e-9 {
+			return false
+		}
+	}
+	return true
+}
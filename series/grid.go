@@ -0,0 +1,89 @@
+// Evenly spaced timestamps that collected series are aligned to
+
+package series
+
+import "time"
+
+// Grid is a sequence of Len timestamps, Step apart, starting at Start
+type Grid struct {
+	Start time.Time
+	Step  time.Duration
+	Len   int
+}
+
+// NewGrid returns the grid of timestamps Step apart that ends at last and
+// starts no earlier than first
+func NewGrid(first, last time.Time, step time.Duration) Grid {
+	if step <= 0 || last.Before(first) {
+		return Grid{Start: last, Step: step}
+	}
+	n := int(last.Sub(first)/step) + 1
+	return Grid{Start: last.Add(-time.Duration(n-1) * step), Step: step, Len: n}
+}
+
+// Time returns the i-th timestamp of the grid
+func (g Grid) Time(i int) time.Time {
+	return g.Start.Add(time.Duration(i) * g.Step)
+}
+
+// End returns the last timestamp of the grid
+func (g Grid) End() time.Time {
+	return g.Time(g.Len - 1)
+}
+
+// Times returns all timestamps of the grid
+func (g Grid) Times() []time.Time {
+	times := make([]time.Time, g.Len)
+	for i := range times {
+		times[i] = g.Time(i)
+	}
+	return times
+}
+
+// Index returns the index of the grid timestamp nearest to t, if t is within
+// half a step of it. Points off the grid by less than that, e.g. because of
+// scraping jitter, still land in their bucket.
+func (g Grid) Index(t time.Time) (int, bool) {
+	if g.Len == 0 || g.Step <= 0 {
+		return 0, false
+	}
+	offset := t.Sub(g.Start) + g.Step/2
+	if offset < 0 {
+		return 0, false
+	}
+	i := int(offset / g.Step)
+	if i >= g.Len {
+		return 0, false
+	}
+	diff := t.Sub(g.Time(i))
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff > g.Step/2 {
+		return 0, false
+	}
+	return i, true
+}
+
+// Spacing returns the most common interval, to the second, between
+// consecutive times of each list, the smallest one on a tie, or 0 if no list
+// has two times
+// Missing points only make some intervals a multiple of the step the times
+// are on, so the most common one is that step unless most points are missing.
+func Spacing(lists ...[]time.Time) time.Duration {
+	counts := make(map[time.Duration]int)
+	var best time.Duration
+	for _, times := range lists {
+		for i := 1; i < len(times); i++ {
+			d := times[i].Sub(times[i-1]).Round(time.Second)
+			if d <= 0 {
+				continue
+			}
+			counts[d]++
+			if best == 0 || counts[d] > counts[best] || counts[d] == counts[best] && d < best {
+				best = d
+			}
+		}
+	}
+	return best
+}
@@ -0,0 +1,75 @@
+package series
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewGrid(t *testing.T) {
+	last := time.Date(2016, 5, 23, 10, 15, 0, 0, time.UTC)
+	g := NewGrid(last.Add(-10*time.Minute-30*time.Second), last, time.Minute)
+	if g.Len != 11 || !g.Start.Equal(last.Add(-10*time.Minute)) || !g.End().Equal(last) {
+		t.Errorf("grid = %+v, want 11 minutes ending at %v", g, last)
+	}
+}
+
+func TestGridIndex(t *testing.T) {
+	start := time.Date(2016, 5, 23, 10, 0, 0, 0, time.UTC)
+	g := Grid{Start: start, Step: time.Minute, Len: 90}
+
+	tests := []struct {
+		t     time.Time
+		index int
+		ok    bool
+	}{
+		{start, 0, true},
+		{start.Add(29 * time.Second), 0, true},
+		{start.Add(31 * time.Second), 1, true},
+		{start.Add(-20 * time.Second), 0, true},
+		{start.Add(-40 * time.Second), 0, false},
+		// Past the hour, where minute:second keys used to collide
+		{start.Add(75*time.Minute + 2*time.Second), 75, true},
+		{start.Add(89*time.Minute + 29*time.Second), 89, true},
+		{start.Add(91 * time.Minute), 0, false},
+		// Same instant in another time zone
+		{start.Add(time.Minute).In(time.FixedZone("IST", 5*3600+1800)), 1, true},
+	}
+	for _, tt := range tests {
+		index, ok := g.Index(tt.t)
+		if index != tt.index || ok != tt.ok {
+			t.Errorf("Index(%v) = %d, %v, want %d, %v", tt.t, index, ok, tt.index, tt.ok)
+		}
+	}
+}
+
+func TestSpacing(t *testing.T) {
+	start := time.Date(2016, 5, 23, 10, 0, 0, 0, time.UTC)
+	at := func(seconds ...int) []time.Time {
+		times := make([]time.Time, len(seconds))
+		for i, s := range seconds {
+			times[i] = start.Add(time.Duration(s) * time.Second)
+		}
+		return times
+	}
+	tests := []struct {
+		lists [][]time.Time
+		want  time.Duration
+	}{
+		{nil, 0},
+		{[][]time.Time{at(0)}, 0},
+		// Missing points make some intervals multiples of the step
+		{[][]time.Time{at(0, 60, 180, 240, 300)}, time.Minute},
+		// Jitter is rounded away
+		{[][]time.Time{{start, start.Add(30300 * time.Millisecond), start.Add(59800 * time.Millisecond), start.Add(90 * time.Second)}}, 30 * time.Second},
+		// The smallest on a tie
+		{[][]time.Time{at(0, 60, 180)}, time.Minute},
+		// Intervals are only taken within a list
+		{[][]time.Time{at(0, 30, 60), at(3600, 3630)}, 30 * time.Second},
+		{[][]time.Time{at(60, 0)}, 0},
+	}
+	for _, tt := range tests {
+		if got := Spacing(tt.lists...); got != tt.want {
+			t.Errorf("Spacing(%v) = %v, want %v", tt.lists, got, tt.want)
+		}
+	}
+}
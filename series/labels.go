@@ -0,0 +1,100 @@
+package series
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Labels identify a series: the entity it belongs to and its metric
+type Labels map[string]string
+
+// Label names
+const (
+	LabelCluster     = "cluster"
+	LabelNode        = "node"
+	LabelNamespace   = "namespace"
+	LabelPod         = "pod"
+	LabelContainer   = "container"
+	LabelMetric      = "metric"
+	LabelAggregation = "aggregation"
+)
+
+// Entity types, from the labels a series has
+const (
+	TypeCluster       = "cluster"
+	TypeNode          = "node"
+	TypeNamespace     = "namespace"
+	TypePod           = "pod"
+	TypeContainer     = "container"
+	TypeFreeContainer = "freecontainer"
+)
+
+// Copy returns a copy of l with the given name/value pairs added
+func (l Labels) Copy(pairs ...string) Labels {
+	c := make(Labels, len(l)+len(pairs)/2)
+	for k, v := range l {
+		c[k] = v
+	}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		c[pairs[i]] = pairs[i+1]
+	}
+	return c
+}
+
+// Type returns the type of entity the labels identify. Every series has a
+// cluster label, so the most specific label present decides.
+func (l Labels) Type() string {
+	switch {
+	case l[LabelContainer] != "" && l[LabelPod] != "":
+		return TypeContainer
+	case l[LabelContainer] != "":
+		return TypeFreeContainer
+	case l[LabelPod] != "":
+		return TypePod
+	case l[LabelNamespace] != "":
+		return TypeNamespace
+	case l[LabelNode] != "":
+		return TypeNode
+	}
+	return TypeCluster
+}
+
+// Name returns the name of the entity as printed and charted: namespace/pod
+// for a pod, namespace/pod/container and node/container for containers,
+// followed by the aggregation if any
+func (l Labels) Name() string {
+	var name string
+	switch l.Type() {
+	case TypeContainer:
+		name = l[LabelNamespace] + "/" + l[LabelPod] + "/" + l[LabelContainer]
+	case TypeFreeContainer:
+		name = l[LabelNode] + "/" + l[LabelContainer]
+	case TypePod:
+		name = l[LabelNamespace] + "/" + l[LabelPod]
+	case TypeNamespace:
+		name = l[LabelNamespace]
+	case TypeNode:
+		name = l[LabelNode]
+	default:
+		name = l[LabelCluster]
+	}
+	if agg := l[LabelAggregation]; agg != "" {
+		name += " " + agg
+	}
+	return name
+}
+
+// String returns the labels as {name="value",...}, sorted by name
+func (l Labels) String() string {
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=" + strconv.Quote(l[name])
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
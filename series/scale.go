@@ -0,0 +1,101 @@
+package series
+
+import (
+	"math"
+	"strconv"
+)
+
+// Display units values are scaled to
+const (
+	KiB             Unit = "KiB"
+	MiB             Unit = "MiB"
+	GiB             Unit = "GiB"
+	TiB             Unit = "TiB"
+	KBPerSecond     Unit = "kB/s"
+	MBPerSecond     Unit = "MB/s"
+	GBPerSecond     Unit = "GB/s"
+	Cores           Unit = "cores"
+	Microseconds    Unit = "µs"
+	Seconds         Unit = "s"
+	Minutes         Unit = "min"
+	Hours           Unit = "h"
+	KiloCount       Unit = "k"
+	MegaCount       Unit = "M"
+	KiloCountPerSec Unit = "k/s"
+	MegaCountPerSec Unit = "M/s"
+)
+
+// Scale is a unit to display values in, and the number of units of the
+// original unit in one of it
+type Scale struct {
+	Unit   Unit
+	Factor float64
+}
+
+// Larger units of each unit, smallest first
+var scales = map[Unit][]Scale{
+	Bytes:          {{KiB, 1 << 10}, {MiB, 1 << 20}, {GiB, 1 << 30}, {TiB, 1 << 40}},
+	BytesPerSecond: {{KBPerSecond, 1e3}, {MBPerSecond, 1e6}, {GBPerSecond, 1e9}},
+	Millicores:     {{Cores, 1e3}},
+	Nanoseconds:    {{Microseconds, 1e3}, {Milliseconds, 1e6}, {Seconds, 1e9}},
+	Milliseconds:   {{Seconds, 1e3}, {Minutes, 60e3}, {Hours, 3600e3}},
+	Count:          {{KiloCount, 1e3}, {MegaCount, 1e6}},
+	CountPerSecond: {{KiloCountPerSec, 1e3}, {MegaCountPerSec, 1e6}},
+}
+
+// ScaleFor returns the largest unit in which max, the largest absolute value
+// to display, is at least 1
+func ScaleFor(unit Unit, max float64) Scale {
+	scale := Scale{unit, 1}
+	for _, s := range scales[unit] {
+		if max >= s.Factor {
+			scale = s
+		}
+	}
+	return scale
+}
+
+// Apply returns a copy of s with its values in the scale's unit
+func (sc Scale) Apply(s *Series) *Series {
+	scaled := *s
+	scaled.Unit = sc.Unit
+	scaled.Values = make([]float64, len(s.Values))
+	for i, v := range s.Values {
+		scaled.Values[i] = v / sc.Factor
+	}
+	scaled.Points = make([]Point, len(s.Points))
+	for i, p := range s.Points {
+		scaled.Points[i] = Point{p.Time, p.Value / sc.Factor}
+	}
+	return &scaled
+}
+
+// Scaled returns the set with values scaled to human-readable units. All
+// series of the same unit get the same scale, chosen by their largest value,
+// so they can be compared.
+func (set Set) Scaled() Set {
+	max := make(map[Unit]float64)
+	for _, s := range set {
+		for _, v := range s.Values {
+			if !IsMissing(v) {
+				max[s.Unit] = math.Max(max[s.Unit], math.Abs(v))
+			}
+		}
+		for _, p := range s.Points {
+			max[s.Unit] = math.Max(max[s.Unit], math.Abs(p.Value))
+		}
+	}
+	scaled := make(Set, len(set))
+	for i, s := range set {
+		scaled[i] = ScaleFor(s.Unit, max[s.Unit]).Apply(s)
+	}
+	return scaled
+}
+
+// FormatValue formats a value with at most 3 decimals, and a missing one as null
+func FormatValue(v float64) string {
+	if IsMissing(v) {
+		return "null"
+	}
+	return strconv.FormatFloat(math.Round(v*1000)/1000, 'f', -1, 64)
+}
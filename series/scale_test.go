@@ -0,0 +1,49 @@
+package series
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScaleFor(t *testing.T) {
+	tests := []struct {
+		unit Unit
+		max  float64
+		want Unit
+	}{
+		{Bytes, 183947264, MiB},
+		{Bytes, 3 << 30, GiB},
+		{Bytes, 512, Bytes},
+		{BytesPerSecond, 2.5e6, MBPerSecond},
+		{Millicores, 250, Millicores},
+		{Millicores, 1500, Cores},
+		{Percent, 1e6, Percent},
+		{Bytes, 0, Bytes},
+	}
+	for _, tt := range tests {
+		if got := ScaleFor(tt.unit, tt.max).Unit; got != tt.want {
+			t.Errorf("ScaleFor(%q, %v) = %q, want %q", tt.unit, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestSetScaled(t *testing.T) {
+	grid := Grid{Start: time.Date(2016, 5, 23, 10, 0, 0, 0, time.UTC), Step: time.Minute, Len: 2}
+	small := New(Labels{LabelNode: "node-1", LabelMetric: "memory/usage"}, grid, []Point{{grid.Time(0), 512 << 10}})
+	large := New(Labels{LabelNode: "node-2", LabelMetric: "memory/usage"}, grid, []Point{{grid.Time(1), 3 << 20}})
+
+	scaled := Set{small, large}.Scaled()
+	// Both in MiB, the unit of the larger one
+	if scaled[0].Unit != MiB || scaled[1].Unit != MiB {
+		t.Errorf("units = %q, %q, want MiB", scaled[0].Unit, scaled[1].Unit)
+	}
+	if scaled[0].Values[0] != 0.5 || scaled[0].Points[0].Value != 0.5 || scaled[1].Values[1] != 3 {
+		t.Errorf("values = %v, %v", scaled[0].Values, scaled[1].Values)
+	}
+	if !IsMissing(scaled[0].Values[1]) || small.Values[0] != 512<<10 {
+		t.Errorf("missing value or original changed: %v, %v", scaled[0].Values, small.Values)
+	}
+	if FormatValue(2.0/3) != "0.667" || FormatValue(Missing()) != "null" {
+		t.Errorf("FormatValue = %s, %s", FormatValue(2.0/3), FormatValue(Missing()))
+	}
+}
@@ -0,0 +1,60 @@
+// Metric values of one entity, with their unit
+
+package series
+
+import (
+	"math"
+	"time"
+)
+
+// Point is a single metric value
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// Series is a metric of one entity, identified by its labels and aligned to a grid
+type Series struct {
+	Labels Labels
+	Unit   Unit
+	Grid   Grid
+	// Points are the values as returned, Values the same values on the grid
+	Points []Point
+	Values []float64
+}
+
+// New returns the series with the given labels, including its metric, with
+// its points aligned to grid. Grid slots without a point hold Missing.
+func New(labels Labels, grid Grid, points []Point) *Series {
+	s := &Series{
+		Labels: labels,
+		Unit:   UnitOf(labels[LabelMetric]),
+		Grid:   grid,
+		Points: points,
+		Values: make([]float64, grid.Len),
+	}
+	for i := range s.Values {
+		s.Values[i] = Missing()
+	}
+	for _, p := range points {
+		if i, ok := grid.Index(p.Time); ok {
+			s.Values[i] = p.Value
+		}
+	}
+	return s
+}
+
+// Name returns the name of the entity of the series, see Labels.Name
+func (s *Series) Name() string {
+	return s.Labels.Name()
+}
+
+// Metric returns the metric of the series
+func (s *Series) Metric() string {
+	return s.Labels[LabelMetric]
+}
+
+// IsMissing reports whether v is a missing value
+func IsMissing(v float64) bool {
+	return math.IsNaN(v)
+}
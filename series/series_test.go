@@ -0,0 +1,28 @@
+package series
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	start := time.Date(2016, 5, 23, 10, 0, 0, 0, time.UTC)
+	grid := Grid{Start: start, Step: time.Minute, Len: 4}
+	labels := Labels{LabelCluster: "k8s-cluster", LabelNamespace: "default", LabelPod: "web-1", LabelMetric: "memory/usage"}
+	s := New(labels, grid, []Point{
+		{start.Add(2 * time.Second), 1.5},
+		{start.Add(2 * time.Minute), 2.25},
+		{start.Add(10 * time.Minute), 9},
+	})
+
+	if s.Name() != "default/web-1" || s.Metric() != "memory/usage" {
+		t.Errorf("name, metric = %q, %q, want default/web-1, memory/usage", s.Name(), s.Metric())
+	}
+	if s.Unit != Bytes {
+		t.Errorf("unit = %q, want %q", s.Unit, Bytes)
+	}
+	if len(s.Values) != 4 || s.Values[0] != 1.5 || !IsMissing(s.Values[1]) ||
+		s.Values[2] != 2.25 || !IsMissing(s.Values[3]) {
+		t.Errorf("values = %v, want [1.5 NaN 2.25 NaN]", s.Values)
+	}
+}
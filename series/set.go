@@ -0,0 +1,91 @@
+package series
+
+import "sort"
+
+// Set is a collection of series, in the order they were collected
+type Set []*Series
+
+// Group is the subset of a set sharing the values of some labels
+type Group struct {
+	Labels Labels
+	Set    Set
+}
+
+// Filter returns the series keep returns true for
+func (set Set) Filter(keep func(*Series) bool) Set {
+	filtered := make(Set, 0, len(set))
+	for _, s := range set {
+		if keep(s) {
+			filtered = append(filtered, s)
+		}
+	}
+	return filtered
+}
+
+// Match returns the series that have all the given labels
+func (set Set) Match(labels Labels) Set {
+	return set.Filter(func(s *Series) bool {
+		for name, value := range labels {
+			if s.Labels[name] != value {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// OfType returns the series of one entity type
+func (set Set) OfType(entityType string) Set {
+	return set.Filter(func(s *Series) bool {
+		return s.Labels.Type() == entityType
+	})
+}
+
+// GroupBy splits the set by the values of the given labels. Groups are in
+// the order their first series appears in the set.
+func (set Set) GroupBy(names ...string) []Group {
+	groups := make([]Group, 0)
+	index := make(map[string]int)
+	for _, s := range set {
+		labels := make(Labels, len(names))
+		for _, name := range names {
+			labels[name] = s.Labels[name]
+		}
+		key := labels.String()
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, Group{Labels: labels})
+		}
+		groups[i].Set = append(groups[i].Set, s)
+	}
+	return groups
+}
+
+// Sort sorts the set by the values of the given labels, in order, keeping
+// the collection order of series that are equal in all of them
+func (set Set) Sort(names ...string) {
+	sort.SliceStable(set, func(i, j int) bool {
+		for _, name := range names {
+			a, b := set[i].Labels[name], set[j].Labels[name]
+			if a != b {
+				return a < b
+			}
+		}
+		return false
+	})
+}
+
+// LabelValues returns the distinct values of a label, in order of appearance
+func (set Set) LabelValues(name string) []string {
+	values := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, s := range set {
+		if v, ok := s.Labels[name]; ok && !seen[v] {
+			seen[v] = true
+			values = append(values, v)
+		}
+	}
+	return values
+}
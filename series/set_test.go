@@ -0,0 +1,70 @@
+package series
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testSet() Set {
+	cluster := Labels{LabelCluster: "k8s-cluster"}
+	return Set{
+		{Labels: cluster.Copy(LabelMetric, "cpu/usage_rate")},
+		{Labels: cluster.Copy(LabelNamespace, "web", LabelPod, "api-2", LabelMetric, "cpu/usage_rate")},
+		{Labels: cluster.Copy(LabelNamespace, "web", LabelPod, "api-1", LabelMetric, "cpu/usage_rate")},
+		{Labels: cluster.Copy(LabelNamespace, "db", LabelPod, "pg-0", LabelMetric, "memory/usage")},
+		{Labels: cluster.Copy(LabelNamespace, "web", LabelPod, "api-1", LabelContainer, "app", LabelMetric, "cpu/usage_rate")},
+		{Labels: cluster.Copy(LabelNode, "node-1", LabelContainer, "kubelet", LabelMetric, "cpu/usage_rate")},
+	}
+}
+
+func names(set Set) []string {
+	n := make([]string, len(set))
+	for i, s := range set {
+		n[i] = s.Name()
+	}
+	return n
+}
+
+func TestLabelsName(t *testing.T) {
+	want := []string{"k8s-cluster", "web/api-2", "web/api-1", "db/pg-0", "web/api-1/app", "node-1/kubelet"}
+	if got := names(testSet()); !reflect.DeepEqual(got, want) {
+		t.Errorf("names = %v, want %v", got, want)
+	}
+	agg := Labels{LabelCluster: "c", LabelNode: "node-1", LabelAggregation: "p95"}
+	if got := agg.Name(); got != "node-1 p95" {
+		t.Errorf("name = %q, want node-1 p95", got)
+	}
+}
+
+func TestSetFilter(t *testing.T) {
+	set := testSet()
+	if got := names(set.OfType(TypePod)); !reflect.DeepEqual(got, []string{"web/api-2", "web/api-1", "db/pg-0"}) {
+		t.Errorf("pods = %v", got)
+	}
+	got := names(set.Match(Labels{LabelPod: "api-1"}))
+	if !reflect.DeepEqual(got, []string{"web/api-1", "web/api-1/app"}) {
+		t.Errorf("api-1 = %v", got)
+	}
+}
+
+func TestSetGroupBy(t *testing.T) {
+	groups := testSet().OfType(TypePod).GroupBy(LabelNamespace, LabelMetric)
+	if len(groups) != 2 {
+		t.Fatalf("got %d groups, want 2", len(groups))
+	}
+	if groups[0].Labels[LabelNamespace] != "web" || len(groups[0].Set) != 2 ||
+		groups[1].Labels[LabelMetric] != "memory/usage" || len(groups[1].Set) != 1 {
+		t.Errorf("groups = %+v", groups)
+	}
+}
+
+func TestSetSort(t *testing.T) {
+	set := testSet().OfType(TypePod)
+	set.Sort(LabelNamespace, LabelPod)
+	if got := names(set); !reflect.DeepEqual(got, []string{"db/pg-0", "web/api-1", "web/api-2"}) {
+		t.Errorf("sorted = %v", got)
+	}
+	if got := testSet().LabelValues(LabelNamespace); !reflect.DeepEqual(got, []string{"web", "db"}) {
+		t.Errorf("namespaces = %v", got)
+	}
+}
@@ -0,0 +1,60 @@
+package series
+
+import "strings"
+
+// Unit of the values of a series
+type Unit string
+
+// Units of the metrics Heapster exports
+const (
+	None           Unit = ""
+	Millicores     Unit = "millicores"
+	Nanoseconds    Unit = "ns"
+	Milliseconds   Unit = "ms"
+	Bytes          Unit = "bytes"
+	BytesPerSecond Unit = "bytes/s"
+	Count          Unit = "count"
+	CountPerSecond Unit = "count/s"
+	Percent        Unit = "%"
+	Ratio          Unit = "ratio"
+)
+
+// UnitOf returns the unit of a Heapster metric such as cpu/usage_rate or
+// network/rx_rate. Unknown metrics have no unit.
+func UnitOf(metric string) Unit {
+	group := metric
+	name := ""
+	if i := strings.Index(metric, "/"); i >= 0 {
+		group, name = metric[:i], metric[i+1:]
+	}
+	rate := strings.HasSuffix(name, "_rate")
+
+	switch {
+	case metric == "uptime":
+		return Milliseconds
+	case name == "node_utilization" || name == "node_reservation":
+		//Fractions of the node's allocatable cpu or memory
+		return Ratio
+	case strings.HasPrefix(name, "inodes"):
+		return Count
+	case strings.HasSuffix(name, "errors") || strings.HasSuffix(name, "errors_rate") ||
+		strings.Contains(name, "page_faults"):
+		if rate {
+			return CountPerSecond
+		}
+		return Count
+	case group == "cpu":
+		if name == "usage" {
+			return Nanoseconds
+		}
+		return Millicores
+	case group == "memory" || group == "filesystem" || group == "ephemeral_storage":
+		return Bytes
+	case group == "network":
+		if rate {
+			return BytesPerSecond
+		}
+		return Bytes
+	}
+	return None
+}
@@ -0,0 +1,31 @@
+package series
+
+import "testing"
+
+func TestUnitOf(t *testing.T) {
+	tests := map[string]Unit{
+		"cpu/usage_rate":           Millicores,
+		"cpu/request":              Millicores,
+		"cpu/usage":                Nanoseconds,
+		"memory/working_set":       Bytes,
+		"memory/major_page_faults": Count,
+		"network/rx_rate":          BytesPerSecond,
+		"network/tx":               Bytes,
+		"network/rx_errors_rate":   CountPerSecond,
+		"filesystem/usage":         Bytes,
+		"filesystem/inodes":        Count,
+		"filesystem/inodes_free":   Count,
+		"cpu/node_utilization":     Ratio,
+		"cpu/node_reservation":     Ratio,
+		"memory/node_utilization":  Ratio,
+		"memory/node_reservation":  Ratio,
+		"memory/node_allocatable":  Bytes,
+		"uptime":                   Milliseconds,
+		"custom/thing":             None,
+	}
+	for metric, want := range tests {
+		if got := UnitOf(metric); got != want {
+			t.Errorf("UnitOf(%q) = %q, want %q", metric, got, want)
+		}
+	}
+}
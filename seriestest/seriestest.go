@@ -0,0 +1,61 @@
+// Package seriestest builds the series used by the tests of the packages
+// working on them
+package seriestest
+
+import (
+	"time"
+
+	"../series"
+)
+
+// Start is the first timestamp of every test series
+var Start = time.Date(2016, 5, 23, 10, 0, 0, 0, time.UTC)
+
+// Grid returns a grid of n timestamps a minute apart from Start
+func Grid(n int) series.Grid {
+	return series.Grid{Start: Start, Step: time.Minute, Len: n}
+}
+
+// Pod returns the labels of a metric of a pod
+func Pod(ns, pod, metric string) series.Labels {
+	return series.Labels{
+		series.LabelCluster:   "k8s-cluster",
+		series.LabelNamespace: ns,
+		series.LabelPod:       pod,
+		series.LabelMetric:    metric,
+	}
+}
+
+// Aligned returns a series of values aligned to Grid, in the unit of its metric
+func Aligned(labels series.Labels, values ...float64) *series.Series {
+	return &series.Series{
+		Labels: labels,
+		Unit:   series.UnitOf(labels[series.LabelMetric]),
+		Grid:   Grid(len(values)),
+		Values: values,
+	}
+}
+
+// Minutes returns a series with a point a minute from Start+from for each value,
+// as returned by Heapster
+func Minutes(labels series.Labels, from time.Duration, values ...float64) *series.Series {
+	s := &series.Series{Labels: labels}
+	for i, v := range values {
+		s.Points = append(s.Points, series.Point{Time: Start.Add(from + time.Duration(i)*time.Minute), Value: v})
+	}
+	return s
+}
+
+// Equal reports whether a and b hold the same values, missing values being
+// equal to each other
+func Equal(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] && !(series.IsMissing(a[i]) && series.IsMissing(b[i])) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,149 @@
+//Serve mode: the latest values polled from Heapster on a Prometheus /metrics endpoint
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"./derive"
+	"./export"
+	"./series"
+	"./tsdb"
+)
+
+// The latest sample of every series polled, by series key
+type latestSet struct {
+	mu      sync.Mutex
+	samples map[string]export.Sample
+}
+
+func newLatestSet() *latestSet {
+	return &latestSet{samples: make(map[string]export.Sample)}
+}
+
+// Keeps the samples newer than those kept of their series, and forgets the
+// series without a sample in the last heapsterRetention, such as deleted pods
+// Returns the number of series updated.
+func (l *latestSet) update(samples []export.Sample, now time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	updated := 0
+	for _, s := range samples {
+		key := s.Labels.String()
+		if kept, ok := l.samples[key]; ok && !s.Time.After(kept.Time) {
+			continue
+		}
+		l.samples[key] = s
+		updated++
+	}
+	for key, s := range l.samples {
+		if s.Time.Before(now.Add(-heapsterRetention)) {
+			delete(l.samples, key)
+		}
+	}
+	return updated
+}
+
+func (l *latestSet) list() []export.Sample {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	samples := make([]export.Sample, 0, len(l.samples))
+	for _, s := range l.samples {
+		samples = append(samples, s)
+	}
+	return samples
+}
+
+// Writes the latest samples in the format the scrape asks for
+func (l *latestSet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	format := export.NegotiateFormat(r.Header.Get("Accept"))
+	w.Header().Set("Content-Type", format.ContentType())
+	if err := export.WritePrometheus(w, l.list(), format); err != nil {
+		fmt.Printf("Warning: cannot write the metrics to %s: %v\n", r.RemoteAddr, err)
+	}
+}
+
+// Parses the arguments of serve, which draws no charts: [<heapster-resolution> [<interval-minutes>]]
+func checkServeArgs(args []string) (int, int) {
+	if len(args) > 2 {
+		fmt.Print(usage)
+		os.Exit(1)
+	}
+	resolution, minutes := 0, -1
+	if len(args) >= 1 && args[0] != "auto" {
+		resolution = atoiArg("heapster-resolution", args[0])
+	}
+	if len(args) == 2 {
+		minutes = atoiArg("interval-minutes", args[1])
+	}
+	return resolution, minutes
+}
+
+// The grid of a poll of [start, end], on the timestamps step apart from
+// Heapster's latest one at startup that its points fall on, so that derived
+// series have the same timestamps as the points they come from
+func pollGrid(start, end, latest time.Time, step time.Duration) series.Grid {
+	if latest.Before(end) {
+		latest = latest.Add(end.Sub(latest) / step * step)
+	}
+	return timeGrid(start, end, latest, step)
+}
+
+// Serves the latest value of every collected and derived series on
+// addr/metrics, polling Heapster every interval until ctx is done
+// The first poll fetches [start, now] so that every series has a value right
+// away, later ones only the last interval and two steps, enough for rate().
+// A poll that fails is retried at the next interval, meanwhile the values of
+// the last one are served. Each poll is written to store if there is one.
+func serve(ctx context.Context, c *collector, store *tsdb.DB, derived []*derive.Metric, start, latest time.Time, step, interval time.Duration, addr string, out *output) {
+	values := newLatestSet()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", values)
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	server := &http.Server{Handler: mux}
+	go func() {
+		if err := server.Serve(ln); err != http.ErrServerClosed {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}()
+
+	p := &poller{
+		c:        c,
+		store:    store,
+		interval: interval,
+		out:      out,
+		grid: func(start, end time.Time) series.Grid {
+			return pollGrid(start, end, latest, step)
+		},
+		next: func(end time.Time) time.Time {
+			return end.Add(-interval - 2*step)
+		},
+		handle: func(set series.Set) string {
+			//Derived metrics may use those derived before them
+			all := set
+			for _, d := range derived {
+				all = append(all, d.Apply(all)...)
+			}
+			updated := values.update(export.Latest(all), time.Now())
+			return fmt.Sprintf("%d series updated, %d series", updated, len(values.list()))
+		},
+	}
+
+	fmt.Printf("Serving http://%s/metrics, polling every %v, interrupt to stop\n", ln.Addr(), interval)
+	p.run(ctx, start, 0)
+	shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server.Shutdown(shutdown)
+	fmt.Printf("\nStopped serving\n")
+}
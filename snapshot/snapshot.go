@@ -0,0 +1,199 @@
+// Package snapshot writes and reads self-describing JSON snapshots of a
+// collection: its window and resolution, the grid its series are aligned
+// to, every series with its labels, unit and points, and the raw Heapster
+// responses they came from, so that charts can be drawn again offline
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"sync"
+	"time"
+
+	"../series"
+)
+
+// Version of the snapshot format
+const Version = 1
+
+// Snapshot is everything a collection produced
+type Snapshot struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	// Command line the snapshot was written by
+	Command []string `json:"command,omitempty"`
+
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Resolution Duration  `json:"resolution"`
+	Grid       Grid      `json:"grid"`
+
+	Series    []Series   `json:"series"`
+	Responses []Response `json:"responses,omitempty"`
+}
+
+// Grid is the grid the series were collected on, see series.Grid
+type Grid struct {
+	Start time.Time `json:"start"`
+	Step  Duration  `json:"step"`
+	Len   int       `json:"len"`
+}
+
+// Series is a collected or derived series, with the points it has values for
+type Series struct {
+	Labels series.Labels `json:"labels"`
+	Unit   series.Unit   `json:"unit"`
+	Points []Point       `json:"points"`
+}
+
+// Point is a value of a series
+type Point struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// Response is a raw Heapster response
+type Response struct {
+	URL  string          `json:"url"`
+	Body json.RawMessage `json:"body"`
+}
+
+// Duration is a time.Duration written as a string, e.g. "1m0s"
+type Duration time.Duration
+
+// MarshalJSON implements json.Marshaler
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// New returns a snapshot of set, collected on grid over [start, end] at the given resolution
+// Missing values are left out of the points.
+func New(set series.Set, grid series.Grid, start, end time.Time, resolution time.Duration) *Snapshot {
+	snap := &Snapshot{
+		Version:    Version,
+		Created:    time.Now().UTC(),
+		Start:      start,
+		End:        end,
+		Resolution: Duration(resolution),
+		Grid:       Grid{Start: grid.Start, Step: Duration(grid.Step), Len: grid.Len},
+		Series:     make([]Series, 0, len(set)),
+	}
+	for _, s := range set {
+		points := make([]Point, 0, len(s.Points))
+		for _, p := range s.Points {
+			if !series.IsMissing(p.Value) && !math.IsInf(p.Value, 0) {
+				points = append(points, Point{Time: p.Time, Value: p.Value})
+			}
+		}
+		snap.Series = append(snap.Series, Series{Labels: s.Labels, Unit: s.Unit, Points: points})
+	}
+	return snap
+}
+
+// SeriesGrid returns the grid of the snapshot
+func (snap *Snapshot) SeriesGrid() series.Grid {
+	return series.Grid{Start: snap.Grid.Start, Step: time.Duration(snap.Grid.Step), Len: snap.Grid.Len}
+}
+
+// Set returns the series of the snapshot aligned to its grid, as they were collected
+func (snap *Snapshot) Set() series.Set {
+	grid := snap.SeriesGrid()
+	set := make(series.Set, len(snap.Series))
+	for i, s := range snap.Series {
+		points := make([]series.Point, len(s.Points))
+		for j, p := range s.Points {
+			points[j] = series.Point{Time: p.Time, Value: p.Value}
+		}
+		set[i] = series.New(s.Labels, grid, points)
+		// Derived series have units of their own
+		set[i].Unit = s.Unit
+	}
+	return set
+}
+
+// Write writes the snapshot to path
+func Write(path string, snap *Snapshot) error {
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Read reads the snapshot at path
+func Read(path string) (*Snapshot, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	snap := &Snapshot{}
+	if err := json.Unmarshal(data, snap); err != nil {
+		return nil, fmt.Errorf("%s: not a snapshot: %v", path, err)
+	}
+	if snap.Version != Version {
+		return nil, fmt.Errorf("%s: unknown snapshot version %d", path, snap.Version)
+	}
+	if snap.Grid.Step <= 0 || snap.Grid.Len < 0 {
+		return nil, fmt.Errorf("%s: invalid grid", path)
+	}
+	return snap, nil
+}
+
+// Recorder keeps raw responses, see heapster.Client.OnResponse
+type Recorder struct {
+	// Most bytes of response bodies kept, 0 for no limit
+	// Responses beyond it, e.g. those of a long watch, are only counted.
+	MaxBytes int
+
+	mu        sync.Mutex
+	responses []Response
+	bytes     int
+	dropped   int
+}
+
+// Record keeps a response, with the password of its URL redacted since
+// snapshots are meant to be shared; it is safe for concurrent use
+func (r *Recorder) Record(rawURL string, body []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.MaxBytes > 0 && (r.dropped > 0 || r.bytes+len(body) > r.MaxBytes) {
+		r.dropped++
+		return
+	}
+	r.bytes += len(body)
+	if u, err := url.Parse(rawURL); err == nil {
+		rawURL = u.Redacted()
+	}
+	r.responses = append(r.responses, Response{URL: rawURL, Body: append(json.RawMessage(nil), body...)})
+}
+
+// Dropped returns the number of responses not kept because of MaxBytes
+func (r *Recorder) Dropped() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.dropped
+}
+
+// Responses returns the responses recorded, in the order they were received
+func (r *Recorder) Responses() []Response {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Response(nil), r.responses...)
+}
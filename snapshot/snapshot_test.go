@@ -0,0 +1,122 @@
+package snapshot
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"../heapster"
+	"../series"
+)
+
+var start = time.Date(2016, 5, 23, 10, 0, 0, 0, time.UTC)
+
+func TestRoundTrip(t *testing.T) {
+	grid := series.Grid{Start: start, Step: time.Minute, Len: 3}
+	pod := series.New(series.Labels{series.LabelNamespace: "web", series.LabelPod: "api-1", series.LabelMetric: "memory/usage"}, grid,
+		[]series.Point{{Time: start, Value: 1 << 20}, {Time: start.Add(2 * time.Minute), Value: 1.5}})
+	derived := &series.Series{
+		Labels: pod.Labels.Copy(series.LabelMetric, "memory/request_utilization"),
+		Unit:   series.Percent,
+		Points: []series.Point{{Time: start, Value: 50}, {Time: start.Add(time.Minute), Value: series.Missing()}},
+	}
+
+	rec := &Recorder{}
+	rec.Record("http://heapster/api/v1/model/nodes/", []byte(`["node-1"]`))
+	snap := New(series.Set{pod, derived}, grid, start.Add(-30*time.Second), start.Add(2*time.Minute), time.Minute)
+	snap.Responses = rec.Responses()
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snapshot.json")
+	if err := Write(path, snap); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(path)
+	for _, want := range []string{`"resolution": "1m0s"`, `"unit": "%"`, `"body": [`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("snapshot has no %s:\n%s", want, data)
+		}
+	}
+
+	read, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !read.Start.Equal(snap.Start) || time.Duration(read.Resolution) != time.Minute || len(read.Responses) != 1 {
+		t.Errorf("read %+v", read)
+	}
+	set := read.Set()
+	if len(set) != 2 || set[0].Grid != grid || set[0].Unit != series.Bytes || set[1].Unit != series.Percent {
+		t.Fatalf("set = %v", set)
+	}
+	if v := set[0].Values; v[0] != 1<<20 || !series.IsMissing(v[1]) || v[2] != 1.5 {
+		t.Errorf("values = %v", v)
+	}
+	if v := set[1].Values; v[0] != 50 || !series.IsMissing(v[1]) {
+		t.Errorf("derived values = %v", v)
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{
+		"chart.json":   `ChartType = line`,
+		"version.json": `{"version": 2, "grid": {"step": "1m0s"}}`,
+		"grid.json":    `{"version": 1, "grid": {"step": "0s"}}`,
+	} {
+		path := filepath.Join(dir, name)
+		ioutil.WriteFile(path, []byte(content), 0644)
+		if _, err := Read(path); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestRecorderMaxBytes(t *testing.T) {
+	rec := &Recorder{MaxBytes: 10}
+	rec.Record("http://heapster/1", []byte(`"1234"`))
+	rec.Record("http://heapster/2", []byte(`"12345"`))
+	// Once one is dropped so are later ones, even if they would fit
+	rec.Record("http://heapster/3", []byte(`1`))
+	if got := rec.Responses(); len(got) != 1 || got[0].URL != "http://heapster/1" {
+		t.Errorf("responses = %v, want the first one", got)
+	}
+	if rec.Dropped() != 2 {
+		t.Errorf("dropped = %d, want 2", rec.Dropped())
+	}
+}
+
+func TestRecordRedactsPassword(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`["node-1"]`))
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	u.User = url.UserPassword("admin", "s3cret")
+
+	rec := &Recorder{}
+	client := heapster.NewClient(u.String())
+	client.OnResponse = rec.Record
+	if _, err := client.ListNodes(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	got := rec.Responses()
+	if len(got) != 1 || strings.Contains(got[0].URL, "s3cret") || !strings.Contains(got[0].URL, "admin:xxxxx@") {
+		t.Errorf("responses = %v, want the URL without its password", got)
+	}
+}
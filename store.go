@@ -0,0 +1,52 @@
+//Keeping the collected series in a local store, with -store
+
+package main
+
+import (
+	"fmt"
+
+	"./series"
+	"./tsdb"
+)
+
+// Opens the store given with -store, or returns nil without one
+// Only one process at a time may open it for writing.
+func openStore(readOnly bool) (*tsdb.DB, error) {
+	if *storeDir == "" {
+		return nil, nil
+	}
+	onCorrupt := func(path string, err error) {
+		fmt.Printf("Warning: skipping corrupt block %s: %v\n", path, err)
+	}
+	db, err := tsdb.Open(*storeDir, tsdb.Options{Retention: *retention, ReadOnly: readOnly, OnCorrupt: onCorrupt})
+	if err != nil {
+		return nil, fmt.Errorf("cannot open store %s: %v", *storeDir, err)
+	}
+	return db, nil
+}
+
+// Appends the points of set not stored yet; does nothing without a store
+// They are written to a block, compacting the older ones, once they span a
+// block window, so that polls do not write a block each, or by closeStore.
+// Returns the number of points stored
+func save(db *tsdb.DB, set series.Set) (int, error) {
+	if db == nil {
+		return 0, nil
+	}
+	n := db.Append(set)
+	flushed, err := db.FlushWindow()
+	if err != nil || !flushed {
+		return n, err
+	}
+	return n, db.Compact()
+}
+
+// Writes the points not written yet and releases the store, if there is one
+func closeStore(db *tsdb.DB) {
+	if db == nil {
+		return
+	}
+	if err := db.Close(); err != nil {
+		fmt.Printf("Warning: cannot write the store %s: %v\n", *storeDir, err)
+	}
+}
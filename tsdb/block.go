@@ -0,0 +1,246 @@
+// Block files: the series of a time range, written once and never modified
+//
+// A block file is the magic "HMCB" and a format version, then the time range
+// of the block and its series, each with its labels, time range and chunk.
+// Integers are varints, strings are prefixed with their length, and the file
+// ends with the CRC32 (Castagnoli) of everything before it.
+
+package tsdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"../series"
+)
+
+const (
+	blockMagic   = "HMCB"
+	blockVersion = 1
+	blockSuffix  = ".block"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// block is an open block file, with the index of its series
+// Chunks are read from the file when queried
+type block struct {
+	path       string
+	seq        int
+	mint, maxt int64
+	series     []blockSeries
+}
+
+// blockSeries is a series of a block, and where its chunk is in the file
+type blockSeries struct {
+	labels     series.Labels
+	mint, maxt int64
+	n          int
+	offset     int64
+	length     int
+}
+
+func blockPath(dir string, seq int) string {
+	return filepath.Join(dir, fmt.Sprintf("%08d%s", seq, blockSuffix))
+}
+
+// writeBlock writes the series to a new block file and returns it opened
+// The file is written under a temporary name and renamed once complete
+func writeBlock(dir string, seq int, set []*memSeries) (*block, error) {
+	sort.Slice(set, func(i, j int) bool {
+		return set[i].labels.String() < set[j].labels.String()
+	})
+	b := &block{path: blockPath(dir, seq), seq: seq, mint: set[0].mint, maxt: set[0].maxt}
+	for _, s := range set {
+		if s.mint < b.mint {
+			b.mint = s.mint
+		}
+		if s.maxt > b.maxt {
+			b.maxt = s.maxt
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(blockMagic)
+	buf.WriteByte(blockVersion)
+	putVarint(&buf, b.mint)
+	putVarint(&buf, b.maxt)
+	putUvarint(&buf, uint64(len(set)))
+	for _, s := range set {
+		names := make([]string, 0, len(s.labels))
+		for name := range s.labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		putUvarint(&buf, uint64(len(names)))
+		for _, name := range names {
+			putString(&buf, name)
+			putString(&buf, s.labels[name])
+		}
+		putVarint(&buf, s.mint)
+		putVarint(&buf, s.maxt)
+		putUvarint(&buf, uint64(s.chunk.n))
+		data := s.chunk.bytes()
+		putUvarint(&buf, uint64(len(data)))
+		b.series = append(b.series, blockSeries{
+			labels: s.labels,
+			mint:   s.mint,
+			maxt:   s.maxt,
+			n:      s.chunk.n,
+			offset: int64(buf.Len()),
+			length: len(data),
+		})
+		buf.Write(data)
+	}
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.Checksum(buf.Bytes(), castagnoli))
+	buf.Write(sum[:])
+
+	tmp := b.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, b.path); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// openBlock reads the index of a block file, checking it is complete
+func openBlock(path string, seq int) (*block, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(blockMagic)+1+4 || string(data[:len(blockMagic)]) != blockMagic {
+		return nil, fmt.Errorf("%s: not a block file", path)
+	}
+	if v := data[len(blockMagic)]; v != blockVersion {
+		return nil, fmt.Errorf("%s: unknown block version %d", path, v)
+	}
+	body := data[:len(data)-4]
+	if crc32.Checksum(body, castagnoli) != binary.BigEndian.Uint32(data[len(data)-4:]) {
+		return nil, fmt.Errorf("%s: checksum mismatch", path)
+	}
+
+	b := &block{path: path, seq: seq}
+	d := &decoder{b: body, pos: len(blockMagic) + 1}
+	b.mint = d.varint()
+	b.maxt = d.varint()
+	count := d.uvarint()
+	for i := uint64(0); i < count && d.err == nil; i++ {
+		s := blockSeries{labels: make(series.Labels)}
+		for k := d.uvarint(); k > 0 && d.err == nil; k-- {
+			name := d.string()
+			s.labels[name] = d.string()
+		}
+		s.mint = d.varint()
+		s.maxt = d.varint()
+		s.n = int(d.uvarint())
+		s.length = int(d.uvarint())
+		s.offset = int64(d.pos)
+		d.skip(s.length)
+		b.series = append(b.series, s)
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("%s: %v", path, d.err)
+	}
+	return b, nil
+}
+
+// chunk returns the chunk of a series of the block
+func (b *block) chunk(f *os.File, s blockSeries) (*chunkIterator, error) {
+	data := make([]byte, s.length)
+	if _, err := f.ReadAt(data, s.offset); err != nil {
+		return nil, fmt.Errorf("%s: %v", b.path, err)
+	}
+	return newChunkIterator(data, s.n), nil
+}
+
+func putVarint(buf *bytes.Buffer, v int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	buf.Write(tmp[:binary.PutVarint(tmp[:], v)])
+}
+
+func putUvarint(buf *bytes.Buffer, v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	buf.Write(tmp[:binary.PutUvarint(tmp[:], v)])
+}
+
+func putString(buf *bytes.Buffer, s string) {
+	putUvarint(buf, uint64(len(s)))
+	buf.WriteString(s)
+}
+
+var errTruncated = errors.New("truncated block")
+
+// decoder reads the index of a block, keeping the first error
+type decoder struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.b[d.pos:])
+	if n <= 0 {
+		d.err = errTruncated
+		return 0
+	}
+	d.pos += n
+	return v
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.b[d.pos:])
+	if n <= 0 {
+		d.err = errTruncated
+		return 0
+	}
+	d.pos += n
+	return v
+}
+
+func (d *decoder) skip(n int) {
+	if d.err == nil && (n < 0 || d.pos+n > len(d.b)) {
+		d.err = errTruncated
+	}
+	if d.err == nil {
+		d.pos += n
+	}
+}
+
+func (d *decoder) string() string {
+	n := int(d.uvarint())
+	start := d.pos
+	d.skip(n)
+	if d.err != nil {
+		return ""
+	}
+	return string(d.b[start:d.pos])
+}
@@ -0,0 +1,61 @@
+// Bit streams the chunks are encoded in
+
+package tsdb
+
+import "errors"
+
+var errShortChunk = errors.New("tsdb: chunk ends before its last point")
+
+// bstream is a stream of bits written most significant first
+type bstream struct {
+	b    []byte
+	free uint8 // bits still free in the last byte
+}
+
+func (s *bstream) writeBit(bit bool) {
+	if s.free == 0 {
+		s.b = append(s.b, 0)
+		s.free = 8
+	}
+	s.free--
+	if bit {
+		s.b[len(s.b)-1] |= 1 << s.free
+	}
+}
+
+// writeBits writes the n low bits of u
+func (s *bstream) writeBits(u uint64, n int) {
+	for i := n - 1; i >= 0; i-- {
+		s.writeBit(u>>uint(i)&1 == 1)
+	}
+}
+
+// breader reads back the bits of a bstream
+type breader struct {
+	b   []byte
+	pos int // in bits
+}
+
+func (r *breader) readBit() (bool, error) {
+	if r.pos >= len(r.b)*8 {
+		return false, errShortChunk
+	}
+	bit := r.b[r.pos/8]>>(7-uint(r.pos%8))&1 == 1
+	r.pos++
+	return bit, nil
+}
+
+func (r *breader) readBits(n int) (uint64, error) {
+	var u uint64
+	for i := 0; i < n; i++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		u <<= 1
+		if bit {
+			u |= 1
+		}
+	}
+	return u, nil
+}
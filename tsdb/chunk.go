@@ -0,0 +1,220 @@
+// Compressed chunks of points, as described in Facebook's Gorilla paper:
+// timestamps are encoded as the delta of their deltas, values as the XOR
+// with the previous one, so regularly spaced and slowly changing points
+// take a few bits each
+
+package tsdb
+
+import (
+	"math"
+	"math/bits"
+)
+
+// Encodings of a delta of deltas: a prefix of ones ended by a zero, then
+// the value in as many bits. The last one needs no ending zero.
+var dodEncodings = []struct {
+	prefix uint64
+	prefixBits,
+	valueBits int
+}{
+	{0x0, 1, 0},  // 0: same spacing as the previous point
+	{0x2, 2, 14}, // 10
+	{0x6, 3, 17}, // 110
+	{0xe, 4, 20}, // 1110
+	{0xf, 4, 64}, // 1111
+}
+
+// fits reports whether dod can be encoded in n bits
+func fits(dod int64, n int) bool {
+	switch n {
+	case 0:
+		return dod == 0
+	case 64:
+		return true
+	}
+	return -(int64(1)<<uint(n-1))+1 <= dod && dod <= int64(1)<<uint(n-1)
+}
+
+// chunk is the encoding of the points of one series, in milliseconds
+type chunk struct {
+	bs bstream
+	n  int
+
+	t, tDelta         int64
+	v                 float64
+	leading, trailing uint8
+}
+
+func newChunk() *chunk {
+	return &chunk{leading: 0xff}
+}
+
+// append encodes a point, later than the previous one
+func (c *chunk) append(t int64, v float64) {
+	if c.n == 0 {
+		c.bs.writeBits(uint64(t), 64)
+		c.bs.writeBits(math.Float64bits(v), 64)
+	} else {
+		delta := t - c.t
+		dod := delta - c.tDelta
+		for _, e := range dodEncodings {
+			if fits(dod, e.valueBits) {
+				c.bs.writeBits(e.prefix, e.prefixBits)
+				c.bs.writeBits(uint64(dod), e.valueBits)
+				break
+			}
+		}
+		c.tDelta = delta
+		c.appendValue(v)
+	}
+	c.t, c.v = t, v
+	c.n++
+}
+
+// appendValue encodes the XOR of v with the previous value: a 0 if they are
+// equal, else its meaningful bits, within those of the previous XOR if they
+// fit, or preceded by their number of leading zeros and their length
+func (c *chunk) appendValue(v float64) {
+	xor := math.Float64bits(v) ^ math.Float64bits(c.v)
+	if xor == 0 {
+		c.bs.writeBit(false)
+		return
+	}
+	c.bs.writeBit(true)
+
+	leading := uint8(bits.LeadingZeros64(xor))
+	trailing := uint8(bits.TrailingZeros64(xor))
+	if leading > 31 {
+		leading = 31
+	}
+	if c.leading != 0xff && leading >= c.leading && trailing >= c.trailing {
+		c.bs.writeBit(false)
+		c.bs.writeBits(xor>>c.trailing, 64-int(c.leading)-int(c.trailing))
+		return
+	}
+	c.leading, c.trailing = leading, trailing
+	significant := 64 - int(leading) - int(trailing)
+	c.bs.writeBit(true)
+	c.bs.writeBits(uint64(leading), 5)
+	c.bs.writeBits(uint64(significant), 6) // 64 wraps to 0, as 0 cannot occur
+	c.bs.writeBits(xor>>trailing, significant)
+}
+
+// bytes returns the encoded chunk
+func (c *chunk) bytes() []byte {
+	return c.bs.b
+}
+
+// chunkIterator decodes the n points of an encoded chunk
+type chunkIterator struct {
+	br   breader
+	n, i int
+
+	t, tDelta         int64
+	v                 float64
+	leading, trailing uint8
+	err               error
+}
+
+func newChunkIterator(b []byte, n int) *chunkIterator {
+	return &chunkIterator{br: breader{b: b}, n: n}
+}
+
+// next decodes the next point, and reports whether there was one
+func (it *chunkIterator) next() bool {
+	if it.err != nil || it.i >= it.n {
+		return false
+	}
+	if it.i == 0 {
+		t, err := it.br.readBits(64)
+		if err != nil {
+			return it.fail(err)
+		}
+		v, err := it.br.readBits(64)
+		if err != nil {
+			return it.fail(err)
+		}
+		it.t, it.v = int64(t), math.Float64frombits(v)
+		it.i++
+		return true
+	}
+
+	dod, err := it.readDod()
+	if err != nil {
+		return it.fail(err)
+	}
+	it.tDelta += dod
+	it.t += it.tDelta
+	if err := it.readValue(); err != nil {
+		return it.fail(err)
+	}
+	it.i++
+	return true
+}
+
+func (it *chunkIterator) readDod() (int64, error) {
+	for k, e := range dodEncodings {
+		// Every encoding but the last is ended by a zero
+		if k < len(dodEncodings)-1 {
+			bit, err := it.br.readBit()
+			if err != nil {
+				return 0, err
+			}
+			if bit {
+				continue
+			}
+		}
+		u, err := it.br.readBits(e.valueBits)
+		if err != nil {
+			return 0, err
+		}
+		dod := int64(u)
+		if e.valueBits > 0 && e.valueBits < 64 && u > uint64(1)<<uint(e.valueBits-1) {
+			dod -= int64(1) << uint(e.valueBits)
+		}
+		return dod, nil
+	}
+	panic("unreachable")
+}
+
+func (it *chunkIterator) readValue() error {
+	bit, err := it.br.readBit()
+	if err != nil || !bit {
+		return err
+	}
+	bit, err = it.br.readBit()
+	if err != nil {
+		return err
+	}
+	if bit {
+		leading, err := it.br.readBits(5)
+		if err != nil {
+			return err
+		}
+		significant, err := it.br.readBits(6)
+		if err != nil {
+			return err
+		}
+		if significant == 0 {
+			significant = 64
+		}
+		it.leading = uint8(leading)
+		it.trailing = uint8(64 - leading - significant)
+	}
+	u, err := it.br.readBits(64 - int(it.leading) - int(it.trailing))
+	if err != nil {
+		return err
+	}
+	it.v = math.Float64frombits(math.Float64bits(it.v) ^ u<<it.trailing)
+	return nil
+}
+
+func (it *chunkIterator) fail(err error) bool {
+	it.err = err
+	return false
+}
+
+// at returns the current point
+func (it *chunkIterator) at() (int64, float64) {
+	return it.t, it.v
+}
@@ -0,0 +1,67 @@
+package tsdb
+
+import (
+	"math"
+	"testing"
+)
+
+func TestChunkRoundTrip(t *testing.T) {
+	type point struct {
+		t int64
+		v float64
+	}
+	var points []point
+	ts := int64(1463997600000)
+	// Regular minutes, then irregular and large gaps, with values that repeat,
+	// change slowly, jump and change sign
+	for i := 0; i < 30; i++ {
+		points = append(points, point{ts, 250 + float64(i%3)*0.5})
+		ts += 60000
+	}
+	for _, gap := range []int64{59000, 61000, 1, 600000, 3600000, 86400000 * 30, 60000} {
+		ts += gap
+		points = append(points, point{ts, -float64(gap) / 7})
+	}
+	points = append(points, point{ts + 1, math.MaxFloat64}, point{ts + 2, math.SmallestNonzeroFloat64}, point{ts + 3, 0})
+
+	c := newChunk()
+	for _, p := range points {
+		c.append(p.t, p.v)
+	}
+	it := newChunkIterator(c.bytes(), c.n)
+	for i, want := range points {
+		if !it.next() {
+			t.Fatalf("point %d: no more points, err %v", i, it.err)
+		}
+		if gotT, gotV := it.at(); gotT != want.t || gotV != want.v {
+			t.Fatalf("point %d = (%d, %v), want (%d, %v)", i, gotT, gotV, want.t, want.v)
+		}
+	}
+	if it.next() {
+		t.Errorf("more points than appended")
+	}
+}
+
+func TestChunkCompression(t *testing.T) {
+	c := newChunk()
+	for i := 0; i < 1000; i++ {
+		c.append(int64(i)*60000, 512*1024*1024)
+	}
+	// The first point takes 16 bytes, the first delta 3 more, the others 2 bits each
+	if n := len(c.bytes()); n > 16+3+2*1000/8 {
+		t.Errorf("1000 regular points of the same value take %d bytes", n)
+	}
+}
+
+func TestChunkTruncated(t *testing.T) {
+	c := newChunk()
+	for i := 0; i < 10; i++ {
+		c.append(int64(i)*1000, float64(i*i))
+	}
+	it := newChunkIterator(c.bytes()[:20], c.n)
+	for it.next() {
+	}
+	if it.err == nil {
+		t.Errorf("truncated chunk decoded without error")
+	}
+}
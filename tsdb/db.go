@@ -0,0 +1,524 @@
+// Package tsdb is an embedded, append-only store of the collected series
+//
+// Appended points are kept in memory until flushed to a block file. Blocks
+// are never modified: compaction merges the blocks starting in the same
+// window into a new one, and retention deletes the blocks that are too old.
+// One process at a time may open a store for writing, any number for reading.
+package tsdb
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"../series"
+)
+
+// DefaultBlockDuration is the window blocks are compacted over by default
+const DefaultBlockDuration = 2 * time.Hour
+
+// Name of the file locked by the process writing to a store
+const lockName = "lock"
+
+var (
+	errLocked   = errors.New("the store is in use by another process")
+	errReadOnly = errors.New("the store is open read-only")
+)
+
+func lockPath(dir string) string {
+	return filepath.Join(dir, lockName)
+}
+
+// Options are the retention and compaction settings of a DB
+type Options struct {
+	// ReadOnly opens an existing store to query it only, without locking it,
+	// while another process may be writing to it
+	ReadOnly bool
+	// Blocks ending longer than Retention ago are deleted, 0 keeps them all
+	Retention time.Duration
+	// Blocks starting in the same window of BlockDuration are merged into one
+	BlockDuration time.Duration
+	// OnCorrupt is called for each block that cannot be read, which is left
+	// in place and skipped
+	OnCorrupt func(path string, err error)
+}
+
+// DB is a store in a directory, safe for concurrent use
+type DB struct {
+	dir  string
+	opts Options
+	now  func() time.Time
+	lock *os.File // nil when read-only
+
+	mtx     sync.Mutex
+	blocks  []*block // in the order they were written
+	nextSeq int
+	head    map[string]*memSeries
+	// Time of the last point stored of each series, by its labels
+	last map[string]int64
+}
+
+// memSeries is a series appended to but not flushed yet
+type memSeries struct {
+	labels     series.Labels
+	mint, maxt int64
+	chunk      *chunk
+}
+
+// Open opens the store in dir, creating dir if needed, and locks it for
+// writing unless opts.ReadOnly is set
+func Open(dir string, opts Options) (*DB, error) {
+	if opts.BlockDuration <= 0 {
+		opts.BlockDuration = DefaultBlockDuration
+	}
+	var lock *os.File
+	if opts.ReadOnly {
+		if _, err := os.Stat(dir); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		var err error
+		if lock, err = lockDir(dir); err != nil {
+			return nil, err
+		}
+	}
+	db, err := open(dir, opts)
+	if err != nil {
+		if lock != nil {
+			unlockDir(lock)
+		}
+		return nil, err
+	}
+	db.lock = lock
+	return db, nil
+}
+
+func open(dir string, opts Options) (*DB, error) {
+	db := &DB{
+		dir:     dir,
+		opts:    opts,
+		now:     time.Now,
+		nextSeq: 1,
+		head:    make(map[string]*memSeries),
+		last:    make(map[string]int64),
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		name := f.Name()
+		// Left over by a write that did not complete, or still being written
+		// by the process writing to the store when read-only
+		if strings.HasSuffix(name, blockSuffix+".tmp") {
+			if opts.ReadOnly {
+				continue
+			}
+			if err := os.Remove(db.path(name)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		seq, err := strconv.Atoi(strings.TrimSuffix(name, blockSuffix))
+		if !strings.HasSuffix(name, blockSuffix) || err != nil {
+			continue
+		}
+		b, err := openBlock(db.path(name), seq)
+		if err != nil {
+			if opts.OnCorrupt != nil {
+				opts.OnCorrupt(db.path(name), err)
+			}
+			// New blocks must not overwrite it
+			if seq >= db.nextSeq {
+				db.nextSeq = seq + 1
+			}
+			continue
+		}
+		db.addBlock(b)
+	}
+	sort.Slice(db.blocks, func(i, j int) bool {
+		return db.blocks[i].seq < db.blocks[j].seq
+	})
+	return db, nil
+}
+
+func (db *DB) path(name string) string {
+	return filepath.Join(db.dir, name)
+}
+
+func (db *DB) addBlock(b *block) {
+	db.blocks = append(db.blocks, b)
+	if b.seq >= db.nextSeq {
+		db.nextSeq = b.seq + 1
+	}
+	for _, s := range b.series {
+		key := s.labels.String()
+		if last, ok := db.last[key]; !ok || s.maxt > last {
+			db.last[key] = s.maxt
+		}
+	}
+}
+
+// Append stores the points of the series, and returns how many were stored
+// Points no later than the last one stored of their series are dropped, so
+// overlapping collections can be appended as they are. Missing values are not stored.
+// A read-only DB stores nothing.
+func (db *DB) Append(set series.Set) int {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	if db.opts.ReadOnly {
+		return 0
+	}
+
+	appended := 0
+	for _, s := range set {
+		key := s.Labels.String()
+		for _, p := range s.Points {
+			t := toMillis(p.Time)
+			if last, ok := db.last[key]; (ok && t <= last) || series.IsMissing(p.Value) {
+				continue
+			}
+			ms, ok := db.head[key]
+			if !ok {
+				ms = &memSeries{labels: s.Labels.Copy(), mint: t, chunk: newChunk()}
+				db.head[key] = ms
+			}
+			ms.chunk.append(t, p.Value)
+			ms.maxt = t
+			db.last[key] = t
+			appended++
+		}
+	}
+	return appended
+}
+
+// Flush writes the points appended since the last flush to a new block
+func (db *DB) Flush() error {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	return db.flush()
+}
+
+// FlushWindow flushes once the points appended since the last flush span
+// more than one window of BlockDuration, so that a store appended to on
+// every poll writes about a block per window rather than one per poll
+// Reports whether it flushed.
+func (db *DB) FlushWindow() (bool, error) {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	var mint, maxt int64 = math.MaxInt64, math.MinInt64
+	for _, ms := range db.head {
+		if ms.mint < mint {
+			mint = ms.mint
+		}
+		if ms.maxt > maxt {
+			maxt = ms.maxt
+		}
+	}
+	if len(db.head) == 0 || db.window(mint) == db.window(maxt) {
+		return false, nil
+	}
+	return true, db.flush()
+}
+
+func (db *DB) flush() error {
+	if db.opts.ReadOnly {
+		return errReadOnly
+	}
+	if len(db.head) == 0 {
+		return nil
+	}
+	set := make([]*memSeries, 0, len(db.head))
+	for _, ms := range db.head {
+		set = append(set, ms)
+	}
+	b, err := writeBlock(db.dir, db.nextSeq, set)
+	if err != nil {
+		return err
+	}
+	db.addBlock(b)
+	db.head = make(map[string]*memSeries)
+	return nil
+}
+
+// Compact deletes the blocks past the retention, then merges the blocks
+// starting in the same window of BlockDuration
+// The window of the latest block is left alone while it is still being written to.
+func (db *DB) Compact() error {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	if db.opts.ReadOnly {
+		return errReadOnly
+	}
+	if db.opts.Retention > 0 {
+		cutoff := toMillis(db.now().Add(-db.opts.Retention))
+		kept := db.blocks[:0]
+		for _, b := range db.blocks {
+			if b.maxt >= cutoff {
+				kept = append(kept, b)
+				continue
+			}
+			if err := os.Remove(b.path); err != nil {
+				return err
+			}
+		}
+		db.blocks = kept
+	}
+
+	window := func(b *block) int64 {
+		return db.window(b.mint)
+	}
+	var latest int64 = math.MinInt64
+	for _, b := range db.blocks {
+		if w := window(b); w > latest {
+			latest = w
+		}
+	}
+	groups := make(map[int64][]*block)
+	var windows []int64
+	for _, b := range db.blocks {
+		w := window(b)
+		if w == latest {
+			continue
+		}
+		if _, ok := groups[w]; !ok {
+			windows = append(windows, w)
+		}
+		groups[w] = append(groups[w], b)
+	}
+	for _, w := range windows {
+		if len(groups[w]) < 2 {
+			continue
+		}
+		if err := db.merge(groups[w]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Index of the window of BlockDuration that the time t in milliseconds is in
+func (db *DB) window(t int64) int64 {
+	return floorDiv(t, int64(db.opts.BlockDuration/time.Millisecond))
+}
+
+// merge replaces blocks with one holding all their points
+// The merged block is written before the others are deleted; if that fails
+// midway, the points they share are deduplicated when queried.
+func (db *DB) merge(blocks []*block) error {
+	merged := make(map[string]*memSeries)
+	var keys []string
+	for _, b := range blocks {
+		points, err := b.read(nil, math.MinInt64, math.MaxInt64)
+		if err != nil {
+			return err
+		}
+		for _, s := range points {
+			key := s.Labels.String()
+			ms, ok := merged[key]
+			if !ok {
+				ms = &memSeries{labels: s.Labels, chunk: newChunk()}
+				merged[key] = ms
+				keys = append(keys, key)
+			}
+			for _, p := range s.Points {
+				t := toMillis(p.Time)
+				if ms.chunk.n > 0 && t <= ms.maxt {
+					continue
+				}
+				if ms.chunk.n == 0 {
+					ms.mint = t
+				}
+				ms.chunk.append(t, p.Value)
+				ms.maxt = t
+			}
+		}
+	}
+	set := make([]*memSeries, 0, len(keys))
+	for _, key := range keys {
+		set = append(set, merged[key])
+	}
+	b, err := writeBlock(db.dir, db.nextSeq, set)
+	if err != nil {
+		return err
+	}
+	db.addBlock(b)
+
+	removed := make(map[*block]bool)
+	for _, old := range blocks {
+		if err = os.Remove(old.path); err != nil {
+			break
+		}
+		removed[old] = true
+	}
+	kept := db.blocks[:0]
+	for _, old := range db.blocks {
+		if !removed[old] {
+			kept = append(kept, old)
+		}
+	}
+	db.blocks = kept
+	return err
+}
+
+// Query returns the series matching every matcher with their points in
+// [from, to], sorted by their labels; a zero from or to leaves that end open
+// The series are not aligned to a grid, see series.New.
+func (db *DB) Query(matchers []*Matcher, from, to time.Time) (series.Set, error) {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	mint, maxt := int64(math.MinInt64), int64(math.MaxInt64)
+	if !from.IsZero() {
+		mint = toMillis(from)
+	}
+	if !to.IsZero() {
+		maxt = toMillis(to)
+	}
+
+	found := make(map[string]*series.Series)
+	add := func(set series.Set) {
+		for _, s := range set {
+			key := s.Labels.String()
+			if f, ok := found[key]; ok {
+				f.Points = append(f.Points, s.Points...)
+			} else {
+				found[key] = s
+			}
+		}
+	}
+	for _, b := range db.blocks {
+		if b.maxt < mint || b.mint > maxt {
+			continue
+		}
+		set, err := b.read(matchers, mint, maxt)
+		if err != nil {
+			return nil, err
+		}
+		add(set)
+	}
+	for _, ms := range db.head {
+		if ms.maxt < mint || ms.mint > maxt || !matchAll(matchers, ms.labels) {
+			continue
+		}
+		s := newSeries(ms.labels)
+		it := newChunkIterator(ms.chunk.bytes(), ms.chunk.n)
+		if err := readPoints(s, it, mint, maxt); err != nil {
+			return nil, err
+		}
+		add(series.Set{s})
+	}
+
+	keys := make([]string, 0, len(found))
+	for key := range found {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	result := make(series.Set, 0, len(keys))
+	for _, key := range keys {
+		s := found[key]
+		sort.SliceStable(s.Points, func(i, j int) bool {
+			return s.Points[i].Time.Before(s.Points[j].Time)
+		})
+		// Blocks left over by an interrupted merge repeat points
+		points := s.Points[:0]
+		for _, p := range s.Points {
+			if n := len(points); n > 0 && p.Time.Equal(points[n-1].Time) {
+				continue
+			}
+			points = append(points, p)
+		}
+		s.Points = points
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+// Close flushes and compacts the store and releases its lock
+func (db *DB) Close() error {
+	if db.opts.ReadOnly {
+		return nil
+	}
+	err := db.Flush()
+	if err == nil {
+		err = db.Compact()
+	}
+	if uerr := unlockDir(db.lock); err == nil {
+		err = uerr
+	}
+	return err
+}
+
+// read returns the series of the block matching every matcher with their points in [mint, maxt]
+func (b *block) read(matchers []*Matcher, mint, maxt int64) (series.Set, error) {
+	f, err := os.Open(b.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var set series.Set
+	for _, bs := range b.series {
+		if bs.maxt < mint || bs.mint > maxt || !matchAll(matchers, bs.labels) {
+			continue
+		}
+		it, err := b.chunk(f, bs)
+		if err != nil {
+			return nil, err
+		}
+		s := newSeries(bs.labels)
+		if err := readPoints(s, it, mint, maxt); err != nil {
+			return nil, fmt.Errorf("%s: %v", b.path, err)
+		}
+		set = append(set, s)
+	}
+	return set, nil
+}
+
+func newSeries(labels series.Labels) *series.Series {
+	return &series.Series{Labels: labels.Copy(), Unit: series.UnitOf(labels[series.LabelMetric])}
+}
+
+// readPoints appends the points of a chunk in [mint, maxt] to s
+func readPoints(s *series.Series, it *chunkIterator, mint, maxt int64) error {
+	for it.next() {
+		t, v := it.at()
+		if t < mint || t > maxt {
+			continue
+		}
+		s.Points = append(s.Points, series.Point{Time: fromMillis(t), Value: v})
+	}
+	return it.err
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func fromMillis(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC()
+}
+
+// floorDiv divides rounding towards minus infinity
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
@@ -0,0 +1,308 @@
+package tsdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"../series"
+	"../seriestest"
+)
+
+var start = seriestest.Start
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "tsdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// minuteSeries returns a series of a metric of a web pod with a point per minute from start+from
+func minuteSeries(pod, metric string, from time.Duration, values ...float64) *series.Series {
+	return seriestest.Minutes(seriestest.Pod("web", pod, metric), from, values...)
+}
+
+func values(s *series.Series) []float64 {
+	vs := make([]float64, len(s.Points))
+	for i, p := range s.Points {
+		vs[i] = p.Value
+	}
+	return vs
+}
+
+func mustQuery(t *testing.T, db *DB, selector string, from, to time.Time) series.Set {
+	matchers, err := ParseSelector(selector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, err := db.Query(matchers, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return set
+}
+
+func TestAppendQuery(t *testing.T) {
+	db, err := Open(tempDir(t), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := series.Missing()
+	n := db.Append(series.Set{
+		minuteSeries("api-1", "cpu/usage_rate", 0, 100, 110, m, 130),
+		minuteSeries("api-2", "cpu/usage_rate", 0, 200, 210, 220, 230),
+		minuteSeries("db-1", "memory/usage", 0, 1<<30),
+	})
+	if n != 8 {
+		t.Errorf("appended %d points, want 8", n)
+	}
+
+	// Overlapping collections only add their new points
+	if n := db.Append(series.Set{minuteSeries("api-1", "cpu/usage_rate", 2*time.Minute, 120, 130, 140)}); n != 1 {
+		t.Errorf("appended %d overlapping points, want 1", n)
+	}
+
+	set := mustQuery(t, db, `{pod=~"api-.*",metric="cpu/usage_rate"}`, start.Add(time.Minute), time.Time{})
+	if len(set) != 2 {
+		t.Fatalf("got %d series, want 2", len(set))
+	}
+	if got, want := values(set[0]), []float64{110, 130, 140}; set[0].Labels[series.LabelPod] != "api-1" || !seriestest.Equal(got, want) {
+		t.Errorf("%s: got %v, want %v", set[0].Labels, got, want)
+	}
+	if got, want := values(set[1]), []float64{210, 220, 230}; !seriestest.Equal(got, want) {
+		t.Errorf("%s: got %v, want %v", set[1].Labels, got, want)
+	}
+	if set[0].Unit != series.Millicores || !set[0].Points[0].Time.Equal(start.Add(time.Minute)) {
+		t.Errorf("unit %q, first point at %v", set[0].Unit, set[0].Points[0].Time)
+	}
+}
+
+func TestFlushReopen(t *testing.T) {
+	dir := tempDir(t)
+	db, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Append(series.Set{minuteSeries("api-1", "cpu/usage_rate", 0, 1, 2, 3)})
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	db.Append(series.Set{minuteSeries("api-1", "cpu/usage_rate", 0, 1, 2, 3, 4.5)})
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Appends after reopening are still deduplicated against the stored points
+	if n := db.Append(series.Set{minuteSeries("api-1", "cpu/usage_rate", 0, 1, 2, 3, 4.5, 5)}); n != 1 {
+		t.Errorf("appended %d points after reopening, want 1", n)
+	}
+	set := mustQuery(t, db, `{pod="api-1"}`, time.Time{}, time.Time{})
+	if len(set) != 1 || !seriestest.Equal(values(set[0]), []float64{1, 2, 3, 4.5, 5}) {
+		t.Fatalf("got %v", set)
+	}
+}
+
+func TestCorruptBlock(t *testing.T) {
+	dir := tempDir(t)
+	db, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pod := range []string{"api-1", "api-2"} {
+		db.Append(series.Set{minuteSeries(pod, "cpu/usage_rate", 0, 1, 2, 3)})
+		if err := db.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := blockPath(dir, 1)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 0xff
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The corrupt block is skipped and left as it is, the others are read
+	var corrupt []string
+	db, err = Open(dir, Options{OnCorrupt: func(path string, err error) { corrupt = append(corrupt, path) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if len(corrupt) != 1 || corrupt[0] != path {
+		t.Errorf("got corrupt blocks %v, want %s", corrupt, path)
+	}
+	set := mustQuery(t, db, `{metric="cpu/usage_rate"}`, time.Time{}, time.Time{})
+	if len(set) != 1 || set[0].Labels[series.LabelPod] != "api-2" {
+		t.Errorf("got %d series, want api-2 only", len(set))
+	}
+
+	db.Append(series.Set{minuteSeries("api-3", "cpu/usage_rate", 0, 1)})
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(blockPath(dir, 3)); err != nil {
+		t.Errorf("new block: %v", err)
+	}
+	if after, err := ioutil.ReadFile(path); err != nil || string(after) != string(data) {
+		t.Errorf("corrupt block overwritten: %v", err)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	dir := tempDir(t)
+	db, err := Open(dir, Options{BlockDuration: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A block per 10 minutes over two hours and a half
+	for i := 0; i < 15; i++ {
+		db.Append(series.Set{
+			minuteSeries("api-1", "cpu/usage_rate", time.Duration(i)*10*time.Minute, float64(i), float64(i)+0.5),
+			minuteSeries("api-2", "cpu/usage_rate", time.Duration(i)*10*time.Minute, float64(-i)),
+		})
+		if err := db.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first two hours are merged, the last window is left as it is
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+blockSuffix))
+	if len(db.blocks) != 5 || len(files) != 5 {
+		t.Errorf("%d blocks, %d files after compaction, want 5", len(db.blocks), len(files))
+	}
+	set := mustQuery(t, db, `{}`, time.Time{}, time.Time{})
+	if len(set) != 2 || len(set[0].Points) != 30 || len(set[1].Points) != 15 {
+		t.Fatalf("got %v", set)
+	}
+	for i, p := range set[0].Points {
+		want := start.Add(time.Duration(i/2)*10*time.Minute + time.Duration(i%2)*time.Minute)
+		if !p.Time.Equal(want) || p.Value != float64(i/2)+float64(i%2)/2 {
+			t.Errorf("point %d = %v, want %v at %v", i, p, float64(i/2)+float64(i%2)/2, want)
+		}
+	}
+
+	// Merged blocks survive reopening, and can be read while the store is open
+	db, err = Open(dir, Options{BlockDuration: time.Hour, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set := mustQuery(t, db, `{pod="api-2"}`, start.Add(time.Hour), start.Add(2*time.Hour)); len(set) != 1 || len(set[0].Points) != 7 {
+		t.Errorf("got %v", set)
+	}
+}
+
+func TestLock(t *testing.T) {
+	dir := tempDir(t)
+	db, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Append(series.Set{minuteSeries("api-1", "cpu/usage_rate", 0, 1, 2)})
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// A block being written by the writer is left alone by readers
+	tmp := blockPath(dir, 2) + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(dir, Options{}); err != errLocked {
+		t.Errorf("second writer got %v, want %v", err, errLocked)
+	}
+	reader, err := Open(dir, Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(tmp); err != nil {
+		t.Errorf("reader removed the block being written: %v", err)
+	}
+	if set := mustQuery(t, reader, `{}`, time.Time{}, time.Time{}); len(set) != 1 {
+		t.Errorf("reader got %v", set)
+	}
+	if n := reader.Append(series.Set{minuteSeries("api-2", "cpu/usage_rate", 0, 1)}); n != 0 || reader.Flush() != errReadOnly {
+		t.Errorf("reader appended %d points", n)
+	}
+	if err := reader.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(filepath.Join(dir, "missing"), Options{ReadOnly: true}); err == nil {
+		t.Errorf("opened a missing store read-only")
+	}
+
+	// The lock is released on close
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+}
+
+func TestFlushWindow(t *testing.T) {
+	dir := tempDir(t)
+	db, err := Open(dir, Options{BlockDuration: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// A poll every 10 minutes only writes a block once the head spans two windows
+	flushed := 0
+	for i := 0; i < 12; i++ {
+		db.Append(series.Set{minuteSeries("api-1", "cpu/usage_rate", time.Duration(i)*10*time.Minute, float64(i))})
+		ok, err := db.FlushWindow()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			flushed++
+		}
+	}
+	if flushed != 1 || len(db.blocks) != 1 || len(db.head) != 1 {
+		t.Errorf("flushed %d times, %d blocks, %d series in memory; want 1, 1, 1", flushed, len(db.blocks), len(db.head))
+	}
+	if set := mustQuery(t, db, `{}`, time.Time{}, time.Time{}); len(set) != 1 || len(set[0].Points) != 12 {
+		t.Errorf("got %v", set)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	db, err := Open(tempDir(t), Options{Retention: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.now = func() time.Time { return start.Add(24*time.Hour + 30*time.Minute) }
+
+	db.Append(series.Set{minuteSeries("api-1", "cpu/usage_rate", 0, 1, 2)})
+	db.Flush()
+	db.Append(series.Set{minuteSeries("api-1", "cpu/usage_rate", time.Hour, 3, 4)})
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	set := mustQuery(t, db, `{}`, time.Time{}, time.Time{})
+	if len(set) != 1 || !seriestest.Equal(values(set[0]), []float64{3, 4}) {
+		t.Errorf("got %v, want only the points within the retention", set)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package tsdb
+
+import (
+	"os"
+)
+
+// lockDir creates the lock file of dir, failing if it exists, on platforms
+// without flock
+// A lock file left by a process that did not close the store must be removed by hand.
+func lockDir(dir string) (*os.File, error) {
+	f, err := os.OpenFile(lockPath(dir), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return nil, errLocked
+	}
+	return f, err
+}
+
+func unlockDir(f *os.File) error {
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,130 @@
+// Label selectors, e.g. {namespace="web",pod=~"api-.*"}
+
+package tsdb
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"../series"
+)
+
+// MatchType is the comparison a Matcher makes
+type MatchType string
+
+// Match types
+const (
+	MatchEqual     MatchType = "="
+	MatchNotEqual  MatchType = "!="
+	MatchRegexp    MatchType = "=~"
+	MatchNotRegexp MatchType = "!~"
+)
+
+// Matcher selects the series whose label Name compares to Value
+// A series without the label compares as if its value were empty
+type Matcher struct {
+	Type  MatchType
+	Name  string
+	Value string
+	re    *regexp.Regexp
+}
+
+// NewMatcher returns a matcher, its regular expression anchored at both ends
+func NewMatcher(t MatchType, name, value string) (*Matcher, error) {
+	m := &Matcher{Type: t, Name: name, Value: value}
+	switch t {
+	case MatchEqual, MatchNotEqual:
+	case MatchRegexp, MatchNotRegexp:
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid regexp for label %s: %v", name, err)
+		}
+		m.re = re
+	default:
+		return nil, fmt.Errorf("unknown match type %q", t)
+	}
+	return m, nil
+}
+
+// Matches reports whether a label value matches
+func (m *Matcher) Matches(value string) bool {
+	switch m.Type {
+	case MatchEqual:
+		return value == m.Value
+	case MatchNotEqual:
+		return value != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(value)
+	default:
+		return !m.re.MatchString(value)
+	}
+}
+
+func (m *Matcher) String() string {
+	return m.Name + string(m.Type) + strconv.Quote(m.Value)
+}
+
+// matchAll reports whether labels match every matcher
+func matchAll(matchers []*Matcher, labels series.Labels) bool {
+	for _, m := range matchers {
+		if !m.Matches(labels[m.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseSelector parses a comma separated list of matchers between braces,
+// each a label name, one of = != =~ !~ and a double quoted value
+func ParseSelector(s string) ([]*Matcher, error) {
+	text := strings.TrimSpace(s)
+	if !strings.HasPrefix(text, "{") || !strings.HasSuffix(text, "}") {
+		return nil, fmt.Errorf("invalid selector %q: want {label=\"value\",...}", s)
+	}
+	text = strings.TrimSpace(text[1 : len(text)-1])
+
+	var matchers []*Matcher
+	for text != "" {
+		i := strings.IndexAny(text, "=!")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid selector %q: want a label name before %q", s, text)
+		}
+		name := strings.TrimSpace(text[:i])
+		text = text[i:]
+
+		var t MatchType
+		for _, op := range []MatchType{MatchRegexp, MatchNotRegexp, MatchNotEqual, MatchEqual} {
+			if strings.HasPrefix(text, string(op)) {
+				t = op
+				break
+			}
+		}
+		if t == "" {
+			return nil, fmt.Errorf("invalid selector %q: unknown operator in %q", s, text)
+		}
+		text = strings.TrimSpace(text[len(t):])
+
+		quoted, err := strconv.QuotedPrefix(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector %q: want a quoted value for label %s", s, name)
+		}
+		value, _ := strconv.Unquote(quoted)
+		text = strings.TrimSpace(text[len(quoted):])
+
+		m, err := NewMatcher(t, name, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %v", s, err)
+		}
+		matchers = append(matchers, m)
+
+		if text != "" {
+			if text[0] != ',' {
+				return nil, fmt.Errorf("invalid selector %q: want , between matchers", s)
+			}
+			text = strings.TrimSpace(text[1:])
+		}
+	}
+	return matchers, nil
+}
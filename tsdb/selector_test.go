@@ -0,0 +1,62 @@
+package tsdb
+
+import (
+	"testing"
+
+	"../series"
+)
+
+func TestParseSelector(t *testing.T) {
+	matchers, err := ParseSelector(`{namespace="web", pod=~"api-.*",container!="sidecar", metric!~"memory/.*"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`namespace="web"`, `pod=~"api-.*"`, `container!="sidecar"`, `metric!~"memory/.*"`}
+	if len(matchers) != len(want) {
+		t.Fatalf("got %v, want %v", matchers, want)
+	}
+	for i, m := range matchers {
+		if m.String() != want[i] {
+			t.Errorf("matcher %d = %s, want %s", i, m, want[i])
+		}
+	}
+
+	tests := []struct {
+		labels series.Labels
+		want   bool
+	}{
+		{series.Labels{"namespace": "web", "pod": "api-1", "container": "app", "metric": "cpu/usage_rate"}, true},
+		{series.Labels{"namespace": "web", "pod": "api-1", "metric": "cpu/usage_rate"}, true},
+		{series.Labels{"namespace": "web", "pod": "xapi-1", "metric": "cpu/usage_rate"}, false},
+		{series.Labels{"namespace": "web", "pod": "api-1", "container": "sidecar", "metric": "cpu/usage_rate"}, false},
+		{series.Labels{"namespace": "web", "pod": "api-1", "metric": "memory/usage"}, false},
+		{series.Labels{"namespace": "db", "pod": "api-1", "metric": "cpu/usage_rate"}, false},
+	}
+	for _, test := range tests {
+		if got := matchAll(matchers, test.labels); got != test.want {
+			t.Errorf("%v: got %v, want %v", test.labels, got, test.want)
+		}
+	}
+}
+
+func TestParseSelectorEmpty(t *testing.T) {
+	matchers, err := ParseSelector("{}")
+	if err != nil || len(matchers) != 0 {
+		t.Errorf("got %v, %v, want no matchers", matchers, err)
+	}
+}
+
+func TestParseSelectorErrors(t *testing.T) {
+	for _, s := range []string{
+		`namespace="web"`,
+		`{namespace}`,
+		`{namespace=web}`,
+		`{namespace="web" pod="a"}`,
+		`{pod=~"(api"}`,
+		`{="web"}`,
+	} {
+		if _, err := ParseSelector(s); err == nil {
+			t.Errorf("%s: no error", s)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+//Watch mode: polling Heapster to collect more than its retention window
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"./series"
+	"./tsdb"
+)
+
+// How far back Heapster keeps metrics, and so how far back a series first
+// seen in a later poll is fetched
+const heapsterRetention = 15 * time.Minute
+
+// The points of every series collected while watching
+type watchedSet struct {
+	order  []string //series keys in the order they were first seen
+	series map[string]*watchedSeries
+}
+
+type watchedSeries struct {
+	labels series.Labels
+	points []series.Point
+}
+
+func newWatchedSet() *watchedSet {
+	return &watchedSet{series: make(map[string]*watchedSeries)}
+}
+
+// Appends the points of set newer than the last point kept of their series,
+// and records the latest timestamp of each entity metric in lastSeen
+// Returns the number of points appended and of series seen for the first time
+func (w *watchedSet) merge(set series.Set, lastSeen map[string]time.Time) (int, int) {
+	added, newSeries := 0, 0
+	for _, s := range set {
+		key := s.Labels.String()
+		ws, ok := w.series[key]
+		if !ok {
+			if len(s.Points) == 0 {
+				continue
+			}
+			ws = &watchedSeries{labels: s.Labels}
+			w.series[key] = ws
+			w.order = append(w.order, key)
+			newSeries++
+		}
+		for _, p := range s.Points {
+			if n := len(ws.points); n > 0 && !p.Time.After(ws.points[n-1].Time) {
+				continue
+			}
+			ws.points = append(ws.points, p)
+			added++
+		}
+		if n := len(ws.points); n > 0 {
+			seen := seriesKey(s.Labels, s.Metric())
+			if last := ws.points[n-1].Time; last.After(lastSeen[seen]) {
+				lastSeen[seen] = last
+			}
+		}
+	}
+	return added, newSeries
+}
+
+// Returns all watched series aligned to a grid of step ending at the latest point
+func (w *watchedSet) aligned(step time.Duration) (series.Set, series.Grid) {
+	var first, last time.Time
+	for _, ws := range w.series {
+		if len(ws.points) == 0 {
+			continue
+		}
+		if t := ws.points[0].Time; first.IsZero() || t.Before(first) {
+			first = t
+		}
+		if t := ws.points[len(ws.points)-1].Time; t.After(last) {
+			last = t
+		}
+	}
+	if last.IsZero() {
+		return series.Set{}, series.Grid{Step: step}
+	}
+	grid := series.NewGrid(first.Add(-step/2), last, step)
+	set := make(series.Set, 0, len(w.order))
+	for _, key := range w.order {
+		ws := w.series[key]
+		set = append(set, series.New(ws.labels, grid, ws.points))
+	}
+	return set, grid
+}
+
+// Polling Heapster every interval, shared by watch and serve
+type poller struct {
+	c        *collector
+	store    *tsdb.DB
+	interval time.Duration
+	out      *output
+	// Grid a poll of [start, end] is aligned to
+	grid func(start, end time.Time) series.Grid
+	// Start of the poll after the one ending at end
+	next func(end time.Time) time.Time
+	// Takes the series of a successful poll and returns what to report of them
+	handle func(set series.Set) string
+}
+
+// Polls from start every interval, until ctx is done or for duration if not 0,
+// and reports whether ctx is done
+// A poll that fails, e.g. while Heapster restarts, is retried at the next interval.
+// Each poll is written to the store if there is one.
+func (p *poller) run(ctx context.Context, start time.Time, duration time.Duration) bool {
+	var deadline <-chan time.Time
+	if duration > 0 {
+		deadline = time.After(duration)
+	}
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		end := time.Now()
+		failures := &failureLog{}
+		set, err := p.c.collect(ctx, start, end, p.grid(start, end), p.out, failures)
+		switch {
+		case ctx.Err() != nil:
+		case err != nil:
+			fmt.Printf("%s: Warning: poll failed, retrying in %v: %v\n", end.Format("15:04:05"), p.interval, err)
+		default:
+			fmt.Printf("%s: %s", end.Format("15:04:05"), p.handle(set))
+			if n := len(failures.failures); n > 0 {
+				fmt.Printf(", %d failed requests, e.g. %s: %v", n, failures.failures[0].what, failures.failures[0].err)
+			}
+			fmt.Println()
+			if _, err := save(p.store, set); err != nil {
+				fmt.Printf("%s: Warning: cannot store the poll: %v\n", end.Format("15:04:05"), err)
+			}
+		}
+		start = p.next(end)
+
+		select {
+		case <-ctx.Done():
+			return true
+		case <-deadline:
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// Polls Heapster every interval, until ctx is done or for duration if not 0,
+// and returns everything collected aligned to a grid of step
+// The first poll fetches [start, now], later ones only the points newer than
+// those already seen of each series, or the last heapsterRetention of a new one.
+func watch(ctx context.Context, c *collector, store *tsdb.DB, start time.Time, step, interval, duration time.Duration, out *output) (series.Set, series.Grid) {
+	c.lastSeen = make(map[string]time.Time)
+	watched := newWatchedSet()
+	p := &poller{
+		c:        c,
+		store:    store,
+		interval: interval,
+		out:      out,
+		grid: func(start, end time.Time) series.Grid {
+			return series.NewGrid(start, end, step)
+		},
+		next: func(end time.Time) time.Time {
+			return end.Add(-heapsterRetention)
+		},
+		handle: func(set series.Set) string {
+			added, newSeries := watched.merge(set, c.lastSeen)
+			return fmt.Sprintf("%d new points, %d new series, %d series", added, newSeries, len(watched.order))
+		},
+	}
+
+	fmt.Printf("Watching every %v, interrupt to stop and write the charts\n", interval)
+	if p.run(ctx, start, duration) {
+		fmt.Printf("\nStopped watching\n")
+	}
+	return watched.aligned(step)
+}
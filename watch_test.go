@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"./series"
+)
+
+func TestWatchedSetMerge(t *testing.T) {
+	base := time.Date(2016, 5, 23, 10, 0, 0, 0, time.UTC)
+	minute := func(i int) time.Time { return base.Add(time.Duration(i) * time.Minute) }
+	// A series of pod with points at the given minutes, valued by their minute
+	poll := func(pod, aggregation string, minutes ...int) *series.Series {
+		labels := series.Labels{series.LabelNamespace: "web", series.LabelPod: pod, series.LabelMetric: "cpu/usage_rate"}
+		if aggregation != "" {
+			labels[series.LabelAggregation] = aggregation
+		}
+		points := make([]series.Point, len(minutes))
+		for i, m := range minutes {
+			points[i] = series.Point{Time: minute(m), Value: float64(m)}
+		}
+		return series.New(labels, series.NewGrid(base, minute(10), time.Minute), points)
+	}
+
+	w := newWatchedSet()
+	lastSeen := make(map[string]time.Time)
+	for _, c := range []struct {
+		set                series.Set
+		wantAdded, wantNew int
+	}{
+		{series.Set{poll("api-1", "", 0, 1, 2), poll("api-2", "")}, 3, 1},
+		// Overlapping points are kept once, a series without points is not seen yet
+		{series.Set{poll("api-1", "", 1, 2, 3, 4), poll("api-2", "", 3)}, 3, 1},
+		// Points older than the last one kept are dropped
+		{series.Set{poll("api-1", "", 0, 5), poll("api-1", "max", 4, 5)}, 3, 1},
+		{series.Set{poll("api-1", "", 5)}, 0, 0},
+	} {
+		added, newSeries := w.merge(c.set, lastSeen)
+		if added != c.wantAdded || newSeries != c.wantNew {
+			t.Errorf("merging %d series: got %d points and %d new series, want %d and %d", len(c.set), added, newSeries, c.wantAdded, c.wantNew)
+		}
+	}
+
+	if len(w.order) != 3 {
+		t.Fatalf("got series %v, want 3", w.order)
+	}
+	api1 := w.series[w.order[0]]
+	var minutes []int
+	for _, p := range api1.points {
+		minutes = append(minutes, int(p.Time.Sub(base)/time.Minute))
+	}
+	if len(minutes) != 6 || minutes[0] != 0 || minutes[5] != 5 {
+		t.Errorf("api-1 has points at minutes %v, want 0 to 5", minutes)
+	}
+	// Aggregations share the last seen time of their metric
+	if got := lastSeen[seriesKey(poll("api-1", "", 0).Labels, "cpu/usage_rate")]; !got.Equal(minute(5)) {
+		t.Errorf("api-1 last seen at %v, want %v", got, minute(5))
+	}
+	if got := lastSeen[seriesKey(poll("api-2", "", 0).Labels, "cpu/usage_rate")]; !got.Equal(minute(3)) {
+		t.Errorf("api-2 last seen at %v, want %v", got, minute(3))
+	}
+}